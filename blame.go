@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Who last changed a line, and when
+type blame_line struct {
+	hash   string
+	author string
+	time   time.Time
+}
+
+// columns the blame takes up: an abbreviated hash, the author and the date
+const (
+	BLAME_AUTHOR = 12
+	BLAME_WIDTH  = 8 + 1 + BLAME_AUTHOR + 1 + 10 + 1
+)
+
+// what git blames lines that haven't been committed on
+const UNCOMMITTED = "0000000000000000000000000000000000000000"
+
+// Read the output of git blame --porcelain into who changed each line of the file
+// Details of a commit are only given the first time it comes up, and lines
+// don't have to come in order, so each one is placed by its line number
+func parse_blame(output string) []blame_line {
+	var lines []blame_line
+	commits := map[string]*blame_line{}
+	var current *blame_line
+	final := 0
+	for _, line := range strings.Split(output, "\n") {
+		if strings.HasPrefix(line, "\t") {
+			if current != nil && final > 0 {
+				for len(lines) < final {
+					lines = append(lines, blame_line{})
+				}
+				lines[final-1] = *current
+			}
+			continue
+		}
+		field, value, _ := strings.Cut(line, " ")
+		switch field {
+		case "author":
+			if current != nil {
+				current.author = value
+			}
+		case "author-time":
+			if current != nil {
+				seconds, _ := strconv.ParseInt(value, 10, 64)
+				current.time = time.Unix(seconds, 0)
+			}
+		default:
+			// <hash> <line in the commit> <line in the file> [<lines in this group>]
+			parts := strings.Fields(line)
+			if len(field) != len(UNCOMMITTED) || len(parts) < 3 {
+				continue
+			}
+			if current = commits[field]; current == nil {
+				current = &blame_line{hash: field}
+				commits[field] = current
+			}
+			final, _ = strconv.Atoi(parts[2])
+		}
+	}
+	return lines
+}
+
+// Blame a file as it is in its buffer, so lines that have been edited show as not committed
+func blame_file(file_name string, text string) ([]blame_line, error) {
+	dir, name := split_path(file_name)
+	output, err := run_git(dir, []byte(text), "blame", "--porcelain", "--contents", "-", "--", name)
+	if err != nil {
+		return nil, err
+	}
+	return parse_blame(string(output)), nil
+}
+
+// Blame the current buffer again in the background if it has changed since it was
+// last blamed, one run at a time so typing doesn't pile them up
+func update_blame() {
+	if !editor.blaming || editor.blame_running || editor.blame_version == editor.version {
+		return
+	}
+	editor.blame_running = true
+	editor.blame_version = editor.version
+	file, text := editor.file_name, buffer_text(&editor.buffer_t)
+	go func() {
+		found, err := blame_file(file, text)
+		post_event(func() {
+			i := find_buffer(file)
+			if i < 0 {
+				return
+			}
+			b := &editor.buffers[i]
+			if i == editor.current {
+				b = &editor.buffer_t
+			}
+			b.blame_running = false
+			if !b.blaming {
+				return
+			}
+			if err != nil {
+				set_message("Couldn't blame %s: %s", buffer_name(b), err)
+				b.blaming = false
+				b.blame_lines = nil
+				return
+			}
+			b.blame_lines = found
+			if i == editor.current {
+				update_blame()
+			}
+		})
+	}()
+}
+
+func set_blame(on bool) {
+	if on && (editor.file_name == "" || editor.read_only || editor.new_file) {
+		set_message("Only files in git can be blamed")
+		return
+	}
+	editor.blaming = on
+	editor.blame_lines = nil
+	editor.blame_version = -1
+	if on {
+		update_blame()
+	}
+}
+
+func cmd_blame(args []string) {
+	if on, ok := parse_switch(args, editor.blaming); ok {
+		set_blame(on)
+	}
+}
+
+// Keep to a number of characters, padding or cutting the text to fit
+func fit_text(text string, width int) string {
+	runes := []rune(text)
+	if len(runes) > width {
+		return string(runes[:width])
+	}
+	return text + strings.Repeat(" ", width-len(runes))
+}
+
+func draw_blame(b *buf, row uint) {
+	if row >= editor.used_rows || row >= uint(len(editor.blame_lines)) {
+		add_to_buffer(b, strings.Repeat(" ", BLAME_WIDTH))
+		return
+	}
+	line := editor.blame_lines[row]
+	if line.hash == UNCOMMITTED || line.hash == "" {
+		add_to_buffer(b, fit_text("", 9)+fit_text("Not committed", BLAME_WIDTH-9))
+		return
+	}
+	// lines from the same commit as the one above only get the hash
+	if row > 0 && row != editor.cursor.y && editor.blame_lines[row-1].hash == line.hash {
+		add_to_buffer(b, fmt.Sprintf("\x1b[%dm%s\x1b[m%*s", T_CYAN, line.hash[:8], BLAME_WIDTH-8, ""))
+		return
+	}
+	add_to_buffer(b, fmt.Sprintf("\x1b[%dm%s\x1b[m %s %s ", T_CYAN, line.hash[:8],
+		fit_text(line.author, BLAME_AUTHOR), line.time.Format("2006-01-02")))
+}
+
+// Show the whole message of the commit that last changed the cursor's line
+func show_commit() {
+	if !editor.blaming {
+		set_message("Turn blame on first")
+		return
+	}
+	if editor.cursor.y >= uint(len(editor.blame_lines)) {
+		set_message("Still blaming")
+		return
+	}
+	hash := editor.blame_lines[editor.cursor.y].hash
+	if hash == UNCOMMITTED || hash == "" {
+		set_message("Not committed yet")
+		return
+	}
+	dir, _ := split_path(editor.file_name)
+	output, err := run_git(dir, nil, "log", "-1", "--format=commit %H%nAuthor: %an <%ae>%nDate:   %ad%n%n%B", hash)
+	if err != nil {
+		set_message("Couldn't read commit %s: %s", hash[:8], err)
+		return
+	}
+	show_list("commit", "[commit "+hash[:8]+"]", split_lines(bytes.TrimRight(output, "\n")), nil)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseBlame(t *testing.T) {
+	first := "1111111111111111111111111111111111111111"
+	second := "2222222222222222222222222222222222222222"
+	// lines come grouped by commit, and a commit's details only the first time
+	output := first + " 1 1 2\n" +
+		"author Ada Lovelace\n" +
+		"author-mail <ada@example.com>\n" +
+		"author-time 1700000000\n" +
+		"author-tz +0000\n" +
+		"summary First\n" +
+		"filename main.go\n" +
+		"\tpackage main\n" +
+		first + " 2 2\n" +
+		"\t\n" +
+		UNCOMMITTED + " 3 3 1\n" +
+		"author Not Committed Yet\n" +
+		"author-time 1800000000\n" +
+		"filename main.go\n" +
+		"\tfunc main() {\n" +
+		second + " 3 4 1\n" +
+		"author Grace Hopper\n" +
+		"author-time 1600000000\n" +
+		"previous " + first + " main.go\n" +
+		"filename main.go\n" +
+		"\t}\n"
+
+	lines := parse_blame(output)
+	want := []blame_line{
+		{first, "Ada Lovelace", time.Unix(1700000000, 0)},
+		{first, "Ada Lovelace", time.Unix(1700000000, 0)},
+		{UNCOMMITTED, "Not Committed Yet", time.Unix(1800000000, 0)},
+		{second, "Grace Hopper", time.Unix(1600000000, 0)},
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("parse_blame gave %d lines, want %d: %+v", len(lines), len(want), lines)
+	}
+	for i := range want {
+		if lines[i].hash != want[i].hash || lines[i].author != want[i].author || !lines[i].time.Equal(want[i].time) {
+			t.Errorf("line %d = %+v, want %+v", i+1, lines[i], want[i])
+		}
+	}
+
+	if lines := parse_blame(""); len(lines) != 0 {
+		t.Errorf("parse_blame of nothing = %+v", lines)
+	}
+}
+
+// Lines can come out of order, and each is placed by its line number
+func TestParseBlameOrder(t *testing.T) {
+	first := "1111111111111111111111111111111111111111"
+	second := "2222222222222222222222222222222222222222"
+	output := second + " 1 3 1\nauthor B\nauthor-time 2\n\tc\n" +
+		first + " 1 1 1\nauthor A\nauthor-time 1\n\ta\n" +
+		second + " 2 2 1\n\tb\n"
+	lines := parse_blame(output)
+	got := []string{}
+	for _, line := range lines {
+		got = append(got, line.author)
+	}
+	if len(got) != 3 || got[0] != "A" || got[1] != "B" || got[2] != "B" {
+		t.Errorf("authors by line = %q, want A, B, B", got)
+	}
+}
+
+func TestFitText(t *testing.T) {
+	tests := []struct {
+		text  string
+		width int
+		want  string
+	}{
+		{"Ada", 5, "Ada  "},
+		{"Lovelace", 4, "Love"},
+		{"Åsa Öberg", 5, "Åsa Ö"},
+		{"", 2, "  "},
+	}
+	for _, test := range tests {
+		if got := fit_text(test.text, test.width); got != test.want {
+			t.Errorf("fit_text(%q, %d) = %q, want %q", test.text, test.width, got, test.want)
+		}
+	}
+}
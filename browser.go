@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Path to show for a directory, relative to where the editor was started if it is below it
+func display_path(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	if wd, err := os.Getwd(); err == nil {
+		if rel, err := filepath.Rel(wd, abs); err == nil && !strings.HasPrefix(rel, "..") {
+			return rel
+		}
+	}
+	return abs
+}
+
+// Show the contents of a directory in the file browser
+// Enter opens the file under the cursor or moves into the directory
+func open_directory(dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		set_message("Couldn't read directory: %s", err)
+		return
+	}
+
+	// directories first, each group in name order
+	names := []string{"../"}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			names = append(names, entry.Name()+"/")
+		}
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+
+	title := display_path(dir)
+	if !strings.HasSuffix(title, "/") {
+		title += "/"
+	}
+	show_list("browser", title, names, func(row uint) {
+		if row >= uint(len(names)) {
+			return
+		}
+		path := filepath.Join(dir, names[row])
+		if strings.HasSuffix(names[row], "/") {
+			open_directory(path)
+		} else {
+			open_buffer(path)
+		}
+	})
+	set_message("Enter to open, CTRL-O to type a path")
+}
+
+// Directory of the current file, or where the editor was started
+func current_dir() string {
+	if editor.file_name != "" && !editor.read_only {
+		return filepath.Dir(editor.file_name)
+	}
+	if editor.list_kind == "browser" {
+		return editor.file_name
+	}
+	return "."
+}
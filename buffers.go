@@ -0,0 +1,287 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// A buffer with nothing in it
+func empty_buffer() buffer_t {
+	var b buffer_t
+	b.clean = true
+	b.tab_width = 4
+	return b
+}
+
+// Write the current buffer back to its place in the list
+func sync_buffer() {
+	editor.buffers[editor.current] = editor.buffer_t
+}
+
+func switch_buffer(i int) {
+	if i == editor.current || i < 0 || i >= len(editor.buffers) {
+		return
+	}
+	sync_buffer()
+	editor.current = i
+	editor.buffer_t = editor.buffers[i]
+	editor.quit_attempted = false
+}
+
+// Add an empty buffer after the current one and switch to it
+func add_buffer() {
+	sync_buffer()
+	i := editor.current + 1
+	rest := append([]buffer_t{empty_buffer()}, editor.buffers[i:]...)
+	editor.buffers = append(editor.buffers[:i], rest...)
+	editor.current = i
+	editor.buffer_t = editor.buffers[i]
+	editor.quit_attempted = false
+}
+
+// Whether the current buffer is untouched and can be reused for something else
+func is_scratch() bool {
+	return editor.file_name == "" && editor.used_rows == 0 && editor.clean && !editor.read_only
+}
+
+// Make sure there is a buffer to put new contents in, reusing an empty one
+func fresh_buffer() {
+	if is_scratch() {
+		editor.buffer_t = empty_buffer()
+		return
+	}
+	add_buffer()
+}
+
+// Whether the current buffer can be edited, saying why not if it can't
+func writable() bool {
+	if editor.read_only {
+		set_message("Buffer is read-only")
+		return false
+	}
+	return true
+}
+
+func buffer_name(b *buffer_t) string {
+	if b.file_name == "" {
+		return "[No Name]"
+	}
+	return b.file_name
+}
+
+func same_file(a string, b string) bool {
+	abs_a, err_a := filepath.Abs(a)
+	abs_b, err_b := filepath.Abs(b)
+	return err_a == nil && err_b == nil && abs_a == abs_b
+}
+
+// Find the buffer a file is open in, or -1 if it isn't
+func find_buffer(file_name string) int {
+	sync_buffer()
+	for i := range editor.buffers {
+		b := &editor.buffers[i]
+		if !b.read_only && b.file_name != "" && same_file(b.file_name, file_name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Count the buffers with changes that haven't been saved
+func unsaved_buffers() int {
+	sync_buffer()
+	count := 0
+	for _, b := range editor.buffers {
+		if !b.clean && !b.read_only {
+			count++
+		}
+	}
+	return count
+}
+
+// Open a file in a buffer of its own, or go to the buffer that already has it
+// Directories are shown in the file browser
+func open_buffer(file_name string) bool {
+	file_name = expand_home(file_name)
+	info, err := os.Stat(file_name)
+	if err != nil && !os.IsNotExist(err) {
+		set_message("Couldn't open file: %s", err)
+		return false
+	}
+	if err == nil && info.IsDir() {
+		open_directory(file_name)
+		return true
+	}
+	if i := find_buffer(file_name); i >= 0 {
+		switch_buffer(i)
+		return true
+	}
+
+	fresh_buffer()
+	if err := load_file(file_name); err != nil {
+		set_message("Couldn't open file: %s", err)
+		return false
+	}
+	if !report_conflicts() {
+		set_message("Opened %s", file_name)
+	}
+	return true
+}
+
+// Close the current buffer, refusing to lose unsaved changes unless forced
+func close_buffer(force bool) {
+	if !editor.clean && !editor.read_only && !force {
+		set_message("%s has unsaved changes, use close! to discard them", buffer_name(&editor.buffer_t))
+		return
+	}
+	document_closed()
+	if len(editor.buffers) == 1 {
+		editor.buffer_t = empty_buffer()
+		editor.new_file = true
+		return
+	}
+	i := editor.current
+	editor.buffers = append(editor.buffers[:i], editor.buffers[i+1:]...)
+	if i >= len(editor.buffers) {
+		i = len(editor.buffers) - 1
+	}
+	editor.current = i
+	editor.buffer_t = editor.buffers[i]
+}
+
+// Go forwards or backwards through the buffers, wrapping around at the ends
+func cycle_buffer(step int) {
+	n := len(editor.buffers)
+	switch_buffer(((editor.current+step)%n + n) % n)
+}
+
+// Fill a read-only buffer with lines of text to choose from
+// Enter calls on_enter with the row the cursor is on
+// A buffer of the same kind is reused so lists don't pile up
+func show_list(kind string, title string, lines []string, on_enter func(row uint)) {
+	if reuse := find_list(kind); reuse >= 0 {
+		switch_buffer(reuse)
+		editor.buffer_t = empty_buffer()
+	} else {
+		fresh_buffer()
+	}
+
+	editor.file_name = title
+	editor.list_kind = kind
+	editor.read_only = true
+	editor.on_enter = on_enter
+	add_list_rows(lines)
+	sync_buffer()
+}
+
+// Put rows on the end of the current list buffer
+// Filling a list isn't an edit, so unlike add_line this leaves it unmodified
+func add_list_rows(lines []string) {
+	for _, line := range lines {
+		row := line_t{text: []byte(line), len: uint(len(line))}
+		highlight_line(&row)
+		editor.lines = append(editor.lines, row)
+	}
+	editor.used_rows = uint(len(editor.lines))
+}
+
+// Find the list buffer of some kind, or -1 if there isn't one
+func find_list(kind string) int {
+	sync_buffer()
+	for i := range editor.buffers {
+		if editor.buffers[i].read_only && editor.buffers[i].list_kind == kind {
+			return i
+		}
+	}
+	return -1
+}
+
+// Add lines to the end of a list buffer without switching to it
+// Returns false if there is no list of that kind any more
+func append_to_list(kind string, lines []string) bool {
+	i := find_list(kind)
+	if i < 0 {
+		return false
+	}
+	current := editor.current
+	editor.current = i
+	editor.buffer_t = editor.buffers[i]
+	add_list_rows(lines)
+	sync_buffer()
+	editor.current = current
+	editor.buffer_t = editor.buffers[current]
+	return true
+}
+
+// Names of the open buffers that start with the given text
+func complete_buffer_name(prefix string) []string {
+	sync_buffer()
+	var names []string
+	for i := range editor.buffers {
+		name := buffer_name(&editor.buffers[i])
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Switch to a buffer given its number in the list or its name
+func select_buffer(which string) bool {
+	sync_buffer()
+	if n, err := strconv.Atoi(which); err == nil {
+		if n < 1 || n > len(editor.buffers) {
+			return false
+		}
+		switch_buffer(n - 1)
+		return true
+	}
+	for i := range editor.buffers {
+		if buffer_name(&editor.buffers[i]) == which {
+			switch_buffer(i)
+			return true
+		}
+	}
+	if i := find_buffer(which); i >= 0 {
+		switch_buffer(i)
+		return true
+	}
+	return false
+}
+
+// Show the open buffers in a list, enter switches to the one under the cursor
+func list_buffers() {
+	sync_buffer()
+	var names []string
+	var lines []string
+	for i := range editor.buffers {
+		b := &editor.buffers[i]
+		if b.list_kind == "buffers" {
+			continue
+		}
+		flag := " "
+		if !b.clean && !b.read_only {
+			flag = "+"
+		}
+		names = append(names, buffer_name(b))
+		lines = append(lines, flag+" "+buffer_name(b))
+	}
+	show_list("buffers", "[buffers]", lines, func(row uint) {
+		if row < uint(len(names)) {
+			select_buffer(names[row])
+		}
+	})
+}
+
+// Ask for a file to open, directories are opened in the file browser
+func open_prompt() {
+	file_name := prompt("Open: %s", &prompt_opts{
+		history:  "file",
+		complete: complete_file_name,
+	})
+	if file_name != "" {
+		open_buffer(file_name)
+	}
+}
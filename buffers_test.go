@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestListBuffers(t *testing.T) {
+	saved := editor
+	defer func() { editor = saved }()
+	fresh_editor()
+	editor.quit_attempted = true
+	editor.msg = ""
+
+	show_list("buffers", "[buffers]", []string{"one", "two"}, nil)
+	if !append_to_list("buffers", []string{"three"}) {
+		t.Fatal("the list wasn't found to add to")
+	}
+	if got := buffer_lines(); got != "one\ntwo\nthree" {
+		t.Errorf("list holds %q", got)
+	}
+	if editor.version != 0 || !editor.clean || !editor.quit_attempted || editor.msg != "" {
+		t.Errorf("filling a list counted as an edit: version %d, clean %v, quit attempted %v, message %q",
+			editor.version, editor.clean, editor.quit_attempted, editor.msg)
+	}
+
+	// nothing typed goes into a list, control characters included
+	press(0x01, 0x18, 'x', KEY_TAB, KEY_BACKSPACE, KEY_DEL)
+	if got := buffer_lines(); got != "one\ntwo\nthree" {
+		t.Errorf("typing into a list changed it to %q", got)
+	}
+}
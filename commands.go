@@ -0,0 +1,398 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Something that can be run from the command line
+type command struct {
+	name  string
+	alias string
+	usage string
+	help  string
+	run   func(args []string)
+}
+
+var commands []command
+
+// commands are registered here rather than where the slice is declared
+// since some of them need to look through the list themselves
+func init() {
+	commands = []command{
+		{"write", "w", "[file]", "save the file, optionally under a new name", cmd_write},
+		{"quit", "q", "", "quit, if there are no unsaved changes", func(args []string) { quit(false) }},
+		{"quit!", "q!", "", "quit without saving", func(args []string) { quit(true) }},
+		{"wq", "x", "", "save and quit", cmd_write_quit},
+		{"open", "e", "[file|dir]", "open a file in a new buffer, or browse a directory", cmd_open},
+		{"find", "f", "", "pick a file under the current directory to open", func(args []string) { find_file() }},
+		{"grep", "", "[-F] [-i] pattern [dir]", "search the files under a directory", cmd_grep},
+		{"make", "", "[command]", "run the build command and collect its errors", cmd_make},
+		{"buildcmd", "", "[command]", "show or set the command make runs", cmd_build_command},
+		{"cnext", "cn", "", "go to the next error", func(args []string) { next_error(1) }},
+		{"cprev", "cp", "", "go to the previous error", func(args []string) { next_error(-1) }},
+		{"copen", "cw", "", "list the errors from the last build", func(args []string) { show_quickfix() }},
+		{"hnext", "hn", "", "go to the next change since the last commit", func(args []string) { next_hunk(1) }},
+		{"hprev", "hp", "", "go to the previous change since the last commit", func(args []string) { next_hunk(-1) }},
+		{"hrevert", "hr", "", "undo the change under the cursor back to the last commit", func(args []string) { revert_hunk() }},
+		{"blame", "", "[on|off]", "set or toggle showing who last changed each line", cmd_blame},
+		{"showcommit", "sc", "", "show the commit that last changed the cursor's line", func(args []string) { show_commit() }},
+		{"xnext", "xn", "", "go to the next merge conflict", func(args []string) { next_conflict(1) }},
+		{"xprev", "xp", "", "go to the previous merge conflict", func(args []string) { next_conflict(-1) }},
+		{"resolve", "", "ours|theirs|both|base", "resolve the merge conflict under the cursor", cmd_resolve},
+		{"filter", "!", "command", "replace the selection or buffer with the output of a shell command", cmd_filter},
+		{"buffers", "ls", "", "list the open buffers", func(args []string) { list_buffers() }},
+		{"buffer", "b", "number|name", "switch to another buffer", cmd_buffer},
+		{"bnext", "bn", "", "switch to the next buffer", func(args []string) { cycle_buffer(1) }},
+		{"bprev", "bp", "", "switch to the previous buffer", func(args []string) { cycle_buffer(-1) }},
+		{"close", "bd", "", "close the current buffer", func(args []string) { close_buffer(false) }},
+		{"close!", "bd!", "", "close the current buffer, discarding changes", func(args []string) { close_buffer(true) }},
+		{"goto", "g", "line[:col]|+n|-n|n%", "go to a location in the file", cmd_goto},
+		{"outline", "ol", "", "pick a declaration in the file to jump to", func(args []string) { go_outline() }},
+		{"definition", "def", "", "jump to where the word under the cursor is declared", func(args []string) { go_to_definition() }},
+		{"hover", "", "", "ask the language server about what is under the cursor", func(args []string) { hover() }},
+		{"complete", "", "", "complete the word at the cursor using the language server", func(args []string) { lsp_complete() }},
+		{"tag", "ta", "[name]", "jump to a definition from the tags file", cmd_tag},
+		{"back", "", "", "go back to where the last jump was from", func(args []string) { jump_back() }},
+		{"delword", "", "", "delete the word before the cursor, like alt-backspace, or ctrl-backspace " +
+			"on terminals that send it as its own key", func(args []string) { cmd_delete_word() }},
+		{"undo", "u", "", "undo the last change", func(args []string) { undo() }},
+		{"redo", "", "", "redo the last undone change", func(args []string) { redo() }},
+		{"comment", "", "", "toggle comments on the current line or selection", func(args []string) { toggle_comment() }},
+		{"match", "", "", "jump to the matching bracket", func(args []string) { jump_to_match() }},
+		{"numbers", "nu", "[off|absolute|relative]", "set or cycle the line number mode", cmd_numbers},
+		{"wrap", "", "[off|on|word]", "set or cycle soft wrapping", cmd_wrap},
+		{"autopair", "", "[on|off]", "set or toggle closing of brackets and quotes", cmd_autopair},
+		{"format", "fmt", "", "format the buffer with its language's formatter", cmd_format},
+		{"formatter", "", "[command|off]", "show or set the formatter for this file's language", cmd_formatter},
+		{"formatonsave", "", "[on|off]", "set or toggle formatting before saving", cmd_format_on_save},
+		{"tabwidth", "ts", "n", "set how many columns a tab takes up", cmd_tabwidth},
+		{"expandtab", "et", "[on|off]", "set or toggle inserting spaces for tab", cmd_expandtab},
+		{"retab", "", "tabs|spaces", "convert the file's indentation", cmd_retab},
+		{"help", "h", "[command]", "list the commands or describe one", cmd_help},
+	}
+}
+
+// Look up a command by its name or alias
+func find_command(name string) *command {
+	for i := range commands {
+		if commands[i].name == name || (commands[i].alias != "" && commands[i].alias == name) {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+// Names of the commands that start with the given text, in sorted order
+func complete_command(prefix string) []string {
+	var names []string
+	for _, cmd := range commands {
+		if strings.HasPrefix(cmd.name, prefix) {
+			names = append(names, cmd.name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Split a command line into words
+// Words can be quoted with ' or " and characters escaped with \
+func parse_args(line string) []string {
+	var args []string
+	var word []byte
+	in_word := false
+	var quote byte
+
+	for i := 0; i < len(line); i++ {
+		char := line[i]
+		switch {
+		case char == '\\' && i+1 < len(line) && quote != '\'':
+			i++
+			word = append(word, line[i])
+			in_word = true
+		case quote != 0:
+			if char == quote {
+				quote = 0
+			} else {
+				word = append(word, char)
+			}
+		case char == '"' || char == '\'':
+			quote = char
+			in_word = true
+		case char == ' ' || char == '\t':
+			if in_word {
+				args = append(args, string(word))
+				word = nil
+				in_word = false
+			}
+		default:
+			word = append(word, char)
+			in_word = true
+		}
+	}
+	if in_word {
+		args = append(args, string(word))
+	}
+	return args
+}
+
+// Commands given the rest of the line as it was typed, as their one argument,
+// so it can be handed to the shell untouched
+var raw_args = map[string]bool{
+	"make":      true,
+	"buildcmd":  true,
+	"filter":    true,
+	"formatter": true,
+}
+
+func run_command(line string) {
+	args := parse_args(line)
+	if len(args) == 0 {
+		return
+	}
+	cmd := find_command(args[0])
+	if cmd == nil {
+		set_message("Unknown command: %s", args[0])
+		return
+	}
+	if raw_args[cmd.name] {
+		args = args[:1]
+		if rest := rest_of_line(line); rest != "" {
+			args = append(args, rest)
+		}
+	}
+	cmd.run(args[1:])
+}
+
+// The text after the first word of a line
+func rest_of_line(line string) string {
+	line = strings.TrimLeft(line, " \t")
+	i := strings.IndexAny(line, " \t")
+	if i < 0 {
+		return ""
+	}
+	return strings.TrimSpace(line[i:])
+}
+
+// Completions for the arguments of commands that take something in particular
+var arg_completers = map[string]func(string) []string{
+	"write":   complete_path,
+	"open":    complete_path,
+	"grep":    complete_path,
+	"buffer":  complete_buffer_name,
+	"numbers": func(arg string) []string { return complete_from(line_number_modes, arg) },
+	"wrap":    func(arg string) []string { return complete_from(wrap_modes, arg) },
+	"retab":   func(arg string) []string { return complete_from([]string{"tabs", "spaces"}, arg) },
+	"tag":     complete_tag,
+	"help":    complete_command,
+}
+
+// Words from a list that start with the given text
+func complete_from(words []string, prefix string) []string {
+	var found []string
+	for _, word := range words {
+		if strings.HasPrefix(word, prefix) {
+			found = append(found, word)
+		}
+	}
+	return found
+}
+
+// Complete the command name, or the argument of the command being typed
+func complete_command_line(text string) (int, []string) {
+	args := parse_args(text)
+	if !strings.ContainsAny(text, " \t") {
+		return 0, complete_command(text)
+	}
+	if len(args) == 0 {
+		return len(text), nil
+	}
+	cmd := find_command(args[0])
+	if cmd == nil || arg_completers[cmd.name] == nil {
+		return len(text), nil
+	}
+	return complete_last_word(text, arg_completers[cmd.name])
+}
+
+// Read a command and run it
+func command_prompt() {
+	line := prompt(":%s", &prompt_opts{
+		history:  "command",
+		complete: complete_command_line,
+	})
+	if line != "" {
+		run_command(line)
+	}
+	// commands can act on the selection, but it doesn't outlast them
+	editor.selecting = false
+}
+
+// Read on or off from a command's arguments, toggling the current value if there are none
+func parse_switch(args []string, current bool) (bool, bool) {
+	if len(args) == 0 {
+		return !current, true
+	}
+	switch args[0] {
+	case "on", "true", "yes", "1":
+		return true, true
+	case "off", "false", "no", "0":
+		return false, true
+	}
+	set_message("Expected on or off, got %q", args[0])
+	return current, false
+}
+
+// Pick one of a list of named modes from a command's arguments
+func parse_mode(args []string, modes []string) (byte, bool) {
+	for i, mode := range modes {
+		if args[0] == mode {
+			return byte(i), true
+		}
+	}
+	set_message("Expected one of %s, got %q", strings.Join(modes, ", "), args[0])
+	return 0, false
+}
+
+func cmd_write(args []string) {
+	// a list buffer keeps its name, since it can't be saved under any
+	if !writable() {
+		return
+	}
+	if len(args) > 0 {
+		document_closed()
+		editor.file_name = expand_home(args[0])
+		editor.new_file = false
+		set_language(editor.file_name)
+		for i := range editor.lines {
+			highlight_line(&editor.lines[i])
+		}
+	}
+	save()
+}
+
+func cmd_open(args []string) {
+	if len(args) == 0 {
+		open_directory(current_dir())
+		return
+	}
+	open_buffer(args[0])
+}
+
+func cmd_buffer(args []string) {
+	if len(args) == 0 {
+		list_buffers()
+		return
+	}
+	if !select_buffer(args[0]) {
+		set_message("No buffer %q", args[0])
+	}
+}
+
+func cmd_write_quit(args []string) {
+	save()
+	if editor.clean {
+		quit(false)
+	}
+}
+
+func cmd_goto(args []string) {
+	if len(args) == 0 {
+		goto_prompt()
+		return
+	}
+	if !goto_location(args[0]) {
+		set_message("Invalid location: %q", args[0])
+	}
+}
+
+func cmd_numbers(args []string) {
+	if len(args) == 0 {
+		toggle_line_numbers()
+		return
+	}
+	if mode, ok := parse_mode(args, line_number_modes); ok {
+		set_line_numbers(mode)
+	}
+}
+
+func cmd_wrap(args []string) {
+	if len(args) == 0 {
+		toggle_wrap()
+		return
+	}
+	if mode, ok := parse_mode(args, wrap_modes); ok {
+		set_wrap(mode)
+	}
+}
+
+func cmd_autopair(args []string) {
+	if on, ok := parse_switch(args, editor.auto_pair); ok {
+		set_auto_pair(on)
+	}
+}
+
+func cmd_tabwidth(args []string) {
+	if len(args) == 0 {
+		set_message("Tab width is %d", editor.tab_width)
+		return
+	}
+	width, err := strconv.Atoi(args[0])
+	if err != nil || width < 1 || width > 16 {
+		set_message("Invalid tab width: %q", args[0])
+		return
+	}
+	editor.tab_width = uint(width)
+	set_message("Tab width set to %d", width)
+}
+
+func cmd_expandtab(args []string) {
+	if on, ok := parse_switch(args, editor.expand_tab); ok {
+		editor.expand_tab = on
+		if on {
+			set_message("Tab inserts spaces")
+		} else {
+			set_message("Tab inserts tabs")
+		}
+	}
+}
+
+func cmd_delete_word() {
+	if writable() {
+		begin_edit(EDIT_OTHER)
+		delete_word_back()
+		end_edit()
+	}
+}
+
+func cmd_retab(args []string) {
+	if len(args) == 0 {
+		set_message("Usage: retab tabs|spaces")
+		return
+	}
+	switch args[0] {
+	case "tabs", "t":
+		retab(false)
+	case "spaces", "s":
+		retab(true)
+	default:
+		set_message("Usage: retab tabs|spaces")
+	}
+}
+
+func cmd_help(args []string) {
+	if len(args) > 0 {
+		cmd := find_command(args[0])
+		if cmd == nil {
+			set_message("Unknown command: %s", args[0])
+			return
+		}
+		set_message("%s %s: %s", cmd.name, cmd.usage, cmd.help)
+		return
+	}
+	var names []string
+	for _, cmd := range commands {
+		names = append(names, cmd.name)
+	}
+	set_message("Commands: %s", strings.Join(names, " "))
+}
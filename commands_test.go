@@ -0,0 +1,60 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseArgs(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{"", nil},
+		{"  write  a.go ", []string{"write", "a.go"}},
+		{`open "my file.go"`, []string{"open", "my file.go"}},
+		{`grep 'a\b' dir`, []string{"grep", `a\b`, "dir"}},
+		{`grep a\ b`, []string{"grep", "a b"}},
+		{`write ""`, []string{"write", ""}},
+	}
+	for _, test := range tests {
+		if got := parse_args(test.line); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("parse_args(%q) = %q, want %q", test.line, got, test.want)
+		}
+	}
+}
+
+func TestRestOfLine(t *testing.T) {
+	tests := []struct {
+		line, want string
+	}{
+		{"make", ""},
+		{"make   ", ""},
+		{`make go test -run 'Test A' ./...`, `go test -run 'Test A' ./...`},
+		{"\tbuildcmd\tcc  \"$FILE\" ", `cc  "$FILE"`},
+	}
+	for _, test := range tests {
+		if got := rest_of_line(test.line); got != test.want {
+			t.Errorf("rest_of_line(%q) = %q, want %q", test.line, got, test.want)
+		}
+	}
+}
+
+// Put the editor back to a single empty buffer, as it starts
+func fresh_editor() {
+	editor.buffer_t = empty_buffer()
+	editor.buffers = []buffer_t{editor.buffer_t}
+	editor.current = 0
+}
+
+func TestWriteListBuffer(t *testing.T) {
+	saved := editor
+	defer func() { editor = saved }()
+	fresh_editor()
+
+	show_list("buffers", "[buffers]", []string{"one"}, nil)
+	cmd_write([]string{"out.txt"})
+	if editor.file_name != "[buffers]" || !editor.read_only {
+		t.Errorf("writing a list buffer renamed it to %q", editor.file_name)
+	}
+}
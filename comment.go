@@ -0,0 +1,107 @@
+package main
+
+import "bytes"
+
+// Comment or uncomment the current line or the selected lines
+// Uses the language's line comment, or wraps each line in a block comment
+// when it doesn't have one. Markers are lined up at the smallest indentation
+func toggle_comment() {
+	if !writable() {
+		return
+	}
+	start_tok := editor.language.In_line_comment
+	var end_tok []byte
+	if len(start_tok) == 0 {
+		start_tok = editor.language.Start_block_comment
+		end_tok = editor.language.End_block_comment
+	}
+	if len(start_tok) == 0 {
+		set_message("No comment syntax for this file")
+		return
+	}
+	if editor.used_rows == 0 {
+		return
+	}
+	first, last := selected_rows()
+
+	// only uncomment if every line with text on it is already commented
+	commented := true
+	min_width := ^uint(0)
+	for y := first; y <= last; y++ {
+		text := editor.lines[y].text
+		indent := leading_whitespace(text)
+		body := bytes.TrimRight(text[len(indent):], " \t")
+		if len(body) == 0 {
+			continue
+		}
+		if width := indent_width(indent); width < min_width {
+			min_width = width
+		}
+		if !bytes.HasPrefix(body, start_tok) || !bytes.HasSuffix(body, end_tok) {
+			commented = false
+		}
+	}
+	if min_width == ^uint(0) {
+		return
+	}
+
+	begin_edit(EDIT_OTHER)
+	for y := first; y <= last; y++ {
+		line := &editor.lines[y]
+		if len(bytes.TrimSpace(line.text)) == 0 {
+			continue
+		}
+		if commented {
+			uncomment_line(line, y, start_tok, end_tok)
+		} else {
+			comment_line(line, y, min_width, start_tok, end_tok)
+		}
+		line.len = uint(len(line.text))
+		highlight_line(line)
+	}
+	end_edit()
+	modified()
+}
+
+// Put the comment markers around a line, starting at the given indentation
+func comment_line(line *line_t, y uint, width uint, start_tok []byte, end_tok []byte) {
+	var at, col uint
+	for at < line.len && col < width {
+		col += char_width(line.text[at], col)
+		at++
+	}
+
+	marker := append(append([]byte{}, start_tok...), ' ')
+	text := append(append([]byte{}, line.text[:at]...), marker...)
+	text = append(text, line.text[at:]...)
+	update_positions(y, at, 0, uint(len(marker)))
+
+	if len(end_tok) > 0 {
+		text = append(bytes.TrimRight(text, " \t"), ' ')
+		text = append(text, end_tok...)
+	}
+	line.text = text
+}
+
+// Take the comment markers off a line, along with the space next to them
+func uncomment_line(line *line_t, y uint, start_tok []byte, end_tok []byte) {
+	text := line.text
+	if len(end_tok) > 0 {
+		text = bytes.TrimRight(text, " \t")
+		text = bytes.TrimSuffix(text, end_tok)
+		text = bytes.TrimSuffix(text, []byte(" "))
+	}
+
+	at := uint(len(leading_whitespace(text)))
+	removed := uint(len(start_tok))
+	if at+removed < uint(len(text)) && text[at+removed] == ' ' {
+		removed++
+	}
+	line.text = append(append([]byte{}, text[:at]...), text[at+removed:]...)
+	update_positions(y, at, removed, 0)
+	for _, pos := range []*vector{&editor.cursor, &editor.anchor} {
+		if pos.y == y && pos.x > uint(len(line.text)) {
+			pos.x = uint(len(line.text))
+		}
+	}
+}
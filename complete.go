@@ -0,0 +1,133 @@
+package main
+
+import "strings"
+
+// The words being cycled through by repeated word completion
+type word_completion struct {
+	file_name  string
+	start      vector // where the word being completed starts
+	end        vector // where the last completion ended, which the cursor has to still be at
+	prefix     string
+	candidates []string
+	at         int // which candidate is in the buffer, len(candidates) for the prefix
+	version    int // the buffer's version after the last completion, to tell if it's been touched
+}
+
+var completion word_completion
+
+// The words on a line, as runs of characters that is_delimiter doesn't split,
+// leaving out the one containing skip
+func line_words(text []byte, skip int) []string {
+	var words []string
+	for i := 0; i < len(text); {
+		if char_class(text[i]) != 2 {
+			i++
+			continue
+		}
+		start := i
+		for i < len(text) && char_class(text[i]) == 2 {
+			i++
+		}
+		if skip < start || skip > i {
+			words = append(words, string(text[start:i]))
+		}
+	}
+	return words
+}
+
+// Words starting with a prefix, from the lines nearest the cursor outwards
+// and then the other open buffers
+func completion_candidates(prefix string) []string {
+	seen := map[string]bool{prefix: true}
+	var found []string
+	add := func(words []string) {
+		for _, word := range words {
+			if strings.HasPrefix(word, prefix) && !seen[word] {
+				seen[word] = true
+				found = append(found, word)
+			}
+		}
+	}
+
+	y := int(editor.cursor.y)
+	add(line_words(editor.lines[y].text, int(editor.cursor.x)))
+	for d := 1; y-d >= 0 || y+d < int(editor.used_rows); d++ {
+		if y-d >= 0 {
+			add(line_words(editor.lines[y-d].text, -1))
+		}
+		if y+d < int(editor.used_rows) {
+			add(line_words(editor.lines[y+d].text, -1))
+		}
+	}
+
+	sync_buffer()
+	for i := range editor.buffers {
+		if i == editor.current || editor.buffers[i].read_only {
+			continue
+		}
+		for _, line := range editor.buffers[i].lines {
+			add(line_words(line.text, -1))
+		}
+	}
+	return found
+}
+
+// Complete the word before the cursor from words in the open buffers
+// Pressing it again replaces the completion with the next candidate,
+// going back to what was typed after the last one
+func complete_word() {
+	if editor.cursor.y >= editor.used_rows {
+		return
+	}
+	c := &completion
+	cycling := c.candidates != nil && c.file_name == editor.file_name &&
+		c.end == editor.cursor && c.version == editor.version
+
+	if !cycling {
+		line := &editor.lines[editor.cursor.y]
+		start := editor.cursor.x
+		for start > 0 && char_class(line.text[start-1]) == 2 {
+			start--
+		}
+		if start == editor.cursor.x {
+			set_message("Nothing to complete")
+			return
+		}
+		prefix := string(line.text[start:editor.cursor.x])
+		candidates := completion_candidates(prefix)
+		if len(candidates) == 0 {
+			set_message("No completions for %s", prefix)
+			return
+		}
+		*c = word_completion{editor.file_name, vector{start, editor.cursor.y}, editor.cursor, prefix, candidates, -1, 0}
+		begin_edit(EDIT_OTHER)
+	}
+
+	c.at = (c.at + 1) % (len(c.candidates) + 1)
+	word := c.prefix
+	if c.at < len(c.candidates) {
+		word = c.candidates[c.at]
+	}
+	c.end = replace_between(c.start, editor.cursor, []byte(word))
+	editor.cursor = c.end
+	c.version = editor.version
+	show_completions()
+}
+
+// List the candidates in the message bar with the current one marked
+func show_completions() {
+	c := &completion
+	if c.at == len(c.candidates) {
+		set_message("Back to %s", c.prefix)
+		return
+	}
+	words := make([]string, len(c.candidates))
+	for i, word := range c.candidates {
+		words[i] = word
+		if i == c.at {
+			words[i] = "[" + word + "]"
+		}
+	}
+	// start the list at the current one so it's always in view
+	set_message("%d/%d: %s", c.at+1, len(c.candidates), strings.Join(words[c.at:], " "))
+}
@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestCompleteWord(t *testing.T) {
+	saved := editor
+	defer func() { editor = saved; completion = word_completion{} }()
+	fresh_editor()
+
+	set_buffer_text("foobar foobaz\nx = foo")
+	editor.cursor = vector{7, 1}
+	tests := []struct {
+		keys []uint
+		want string
+	}{
+		{[]uint{KEY_WORD_COMP}, "x = foobar"},
+		{[]uint{KEY_WORD_COMP}, "x = foobaz"},
+		{[]uint{KEY_WORD_COMP}, "x = foo"},
+		{[]uint{KEY_WORD_COMP}, "x = foobar"},
+		// moved away from the completion, so there's nothing to complete
+		// rather than the next candidate going in where the cursor is
+		{[]uint{KEY_LEFT, KEY_LEFT, KEY_LEFT, KEY_LEFT, KEY_LEFT, KEY_LEFT, KEY_LEFT, KEY_WORD_COMP}, "x = foobar"},
+		// back where the completion ended it carries on
+		{[]uint{KEY_END, KEY_WORD_COMP}, "x = foobaz"},
+	}
+	for i, test := range tests {
+		press(test.keys...)
+		if got := string(editor.lines[1].text); got != test.want {
+			t.Fatalf("step %d gave %q, want %q", i, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+)
+
+// The rows of a merge conflict's markers
+// base is the ||||||| row of a conflict with the common ancestor in it,
+// and the same as middle in one without
+type conflict struct {
+	start, base, middle, end uint
+}
+
+// Whether a line is a conflict marker made of seven of a character
+// Only the start and end markers are followed by a label
+func is_marker(text []byte, char byte, labelled bool) bool {
+	if len(text) < 7 || !bytes.Equal(text[:7], bytes.Repeat([]byte{char}, 7)) {
+		return false
+	}
+	if len(text) == 7 {
+		return true
+	}
+	return labelled && text[7] == ' '
+}
+
+// Find the conflicts in the buffer again if it has changed since they were last found
+func update_conflicts() {
+	if editor.conflict_version != editor.version {
+		find_conflicts()
+	}
+}
+
+func find_conflicts() {
+	editor.conflicts = nil
+	editor.conflict_version = editor.version
+
+	var c conflict
+	open := false
+	for y := uint(0); y < editor.used_rows; y++ {
+		text := editor.lines[y].text
+		switch {
+		case is_marker(text, '<', true):
+			// a start with no end before it isn't a conflict, so start again here
+			c = conflict{start: y, base: y, middle: y}
+			open = true
+		case !open:
+		case is_marker(text, '|', true) && c.base == c.start:
+			c.base = y
+		case is_marker(text, '=', false) && c.middle == c.start:
+			c.middle = y
+			if c.base == c.start {
+				c.base = y
+			}
+		case is_marker(text, '>', true) && c.middle != c.start:
+			c.end = y
+			editor.conflicts = append(editor.conflicts, c)
+			open = false
+		}
+	}
+}
+
+// Say if a file that has just been opened has conflicts in it
+func report_conflicts() bool {
+	n := len(editor.conflicts)
+	switch {
+	case n == 0:
+		return false
+	case n == 1:
+		set_message("%s has a merge conflict", buffer_name(&editor.buffer_t))
+	default:
+		set_message("%s has %d merge conflicts", buffer_name(&editor.buffer_t), n)
+	}
+	return true
+}
+
+// The conflict a row is in, or nil if it isn't in one
+func conflict_at(row uint) *conflict {
+	for i := range editor.conflicts {
+		c := &editor.conflicts[i]
+		if row >= c.start && row <= c.end {
+			return c
+		}
+	}
+	return nil
+}
+
+// What colour a row is drawn in for being part of a conflict, or H_NONE if it isn't
+func conflict_color(row uint) byte {
+	c := conflict_at(row)
+	switch {
+	case c == nil:
+		return H_NONE
+	case row == c.start || row == c.base || row == c.middle || row == c.end:
+		return H_CONFLICT_MARK
+	case row < c.base:
+		return H_CONFLICT_OURS
+	case row < c.middle:
+		return H_CONFLICT_BASE
+	}
+	return H_CONFLICT_THEIRS
+}
+
+// Move to the next or previous conflict, going round at the ends
+func next_conflict(step int) {
+	update_conflicts()
+	conflicts := editor.conflicts
+	if len(conflicts) == 0 {
+		set_message("No conflicts")
+		return
+	}
+	next_row(len(conflicts), func(i int) uint { return conflicts[i].start }, step, "conflict")
+}
+
+// The lines between two rows, not including either
+func lines_between(from uint, to uint) []string {
+	var lines []string
+	for y := from + 1; y < to; y++ {
+		lines = append(lines, string(editor.lines[y].text))
+	}
+	return lines
+}
+
+// Replace the conflict under the cursor with one or both of its sides,
+// or the common ancestor's version of it
+func resolve_conflict(keep string) {
+	if !writable() {
+		return
+	}
+	update_conflicts()
+	c := conflict_at(editor.cursor.y)
+	if c == nil {
+		set_message("No conflict here")
+		return
+	}
+	ours := lines_between(c.start, c.base)
+	theirs := lines_between(c.middle, c.end)
+	var lines []string
+	switch keep {
+	case "ours":
+		lines = ours
+	case "theirs":
+		lines = theirs
+	case "both":
+		lines = append(ours, theirs...)
+	case "base":
+		if c.base == c.middle {
+			set_message("This conflict doesn't include the base version")
+			return
+		}
+		lines = lines_between(c.base, c.middle)
+	default:
+		set_message("Keep ours, theirs, both or base, not %q", keep)
+		return
+	}
+
+	start := c.start
+	begin_edit(EDIT_OTHER)
+	replace_rows(c.start, c.end+1, lines)
+	end_edit()
+	set_cursor(start, 0)
+	update_conflicts()
+	if n := len(editor.conflicts); n > 0 {
+		set_message("Resolved, %d left", n)
+	} else {
+		set_message("Resolved, no conflicts left")
+	}
+}
+
+func cmd_resolve(args []string) {
+	if len(args) != 1 {
+		set_message("Usage: resolve ours|theirs|both|base")
+		return
+	}
+	resolve_conflict(strings.ToLower(args[0]))
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Put some text in a fresh buffer, one line per line
+func set_buffer_text(text string) {
+	editor.buffer_t = empty_buffer()
+	for i, line := range strings.Split(text, "\n") {
+		add_line(uint(i), []byte(line))
+	}
+}
+
+func buffer_lines() string {
+	lines := make([]string, editor.used_rows)
+	for i := range lines {
+		lines[i] = string(editor.lines[i].text)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestFindConflicts(t *testing.T) {
+	saved := editor.buffer_t
+	defer func() { editor.buffer_t = saved }()
+
+	tests := []struct {
+		text string
+		want []conflict
+	}{
+		{"no conflicts here", nil},
+		{"a\n<<<<<<< HEAD\nours\n=======\ntheirs\n>>>>>>> branch\nb", []conflict{{1, 3, 3, 5}}},
+		{"<<<<<<< ours\nx\n||||||| base\ny\n=======\nz\n>>>>>>> theirs", []conflict{{0, 2, 4, 6}}},
+		{"<<<<<<<\n=======\n>>>>>>>\n<<<<<<< a\n1\n=======\n2\n>>>>>>> b", []conflict{{0, 1, 1, 2}, {3, 5, 5, 7}}},
+		// a start with no end, then a real one
+		{"<<<<<<< stray\n<<<<<<< a\n=======\n>>>>>>> b", []conflict{{1, 2, 2, 3}}},
+		// markers have to be exactly seven characters, and only start and end take labels
+		{"<<<<<<<< a\n=======\n>>>>>>> b", nil},
+		{"<<<<<<< a\n======= x\n>>>>>>> b", nil},
+		{"<<<<<<<a\n=======\n>>>>>>> b", nil},
+		// an end before the middle isn't one
+		{"<<<<<<< a\n>>>>>>> b\n=======", nil},
+	}
+	for _, test := range tests {
+		set_buffer_text(test.text)
+		find_conflicts()
+		if !reflect.DeepEqual(editor.conflicts, test.want) {
+			t.Errorf("find_conflicts() in %q = %v, want %v", test.text, editor.conflicts, test.want)
+		}
+	}
+}
+
+func TestResolveConflict(t *testing.T) {
+	saved := editor.buffer_t
+	defer func() { editor.buffer_t = saved }()
+
+	text := "before\n<<<<<<< HEAD\nmine\n||||||| base\norig\n=======\nyours\nalso yours\n>>>>>>> topic\nafter"
+	tests := []struct {
+		keep, want string
+	}{
+		{"ours", "before\nmine\nafter"},
+		{"theirs", "before\nyours\nalso yours\nafter"},
+		{"both", "before\nmine\nyours\nalso yours\nafter"},
+		{"base", "before\norig\nafter"},
+		{"nonsense", text},
+	}
+	for _, test := range tests {
+		set_buffer_text(text)
+		editor.cursor = vector{0, 3}
+		resolve_conflict(test.keep)
+		if got := buffer_lines(); got != test.want {
+			t.Errorf("resolve_conflict(%q) gave %q, want %q", test.keep, got, test.want)
+		}
+	}
+
+	// without a base section there's nothing to keep for base
+	set_buffer_text("<<<<<<< a\nx\n=======\ny\n>>>>>>> b")
+	editor.cursor = vector{0, 1}
+	resolve_conflict("base")
+	if len(editor.conflicts) != 1 || editor.used_rows != 5 {
+		t.Errorf("resolving to a missing base changed the buffer to %q", buffer_lines())
+	}
+}
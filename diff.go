@@ -0,0 +1,128 @@
+package main
+
+// most edits the diff will search through before calling everything
+// between the common start and end one big change
+const DIFF_LIMIT = 2000
+
+// A run of lines that differs between two versions of a file
+// old lines [old_start, old_start+old_count) became new lines [new_start, new_start+new_count)
+type diff_hunk struct {
+	old_start, old_count int
+	new_start, new_count int
+}
+
+// The hunks that turn a into b, in order
+func diff_lines(a []string, b []string) []diff_hunk {
+	// most changes are small, so the lines either side of them are taken off first
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	a, b = a[pre:len(a)-suf], b[pre:len(b)-suf]
+	match := match_lines(a, b)
+
+	var hunks []diff_hunk
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		if i < len(a) && j < len(b) && match != nil && match[i] == j {
+			i++
+			j++
+			continue
+		}
+		h := diff_hunk{old_start: pre + i, new_start: pre + j}
+		for i < len(a) && (match == nil || match[i] < 0) {
+			i++
+		}
+		// the lines of b before the next one a shares are new
+		next := len(b)
+		if i < len(a) {
+			next = match[i]
+		}
+		j = next
+		h.old_count = pre + i - h.old_start
+		h.new_count = pre + j - h.new_start
+		hunks = append(hunks, h)
+	}
+	return hunks
+}
+
+// Which line of b each line of a is kept as, or -1 if it isn't, by Myers' algorithm
+// Returns nil if they differ by more than DIFF_LIMIT edits
+func match_lines(a []string, b []string) []int {
+	n, m := len(a), len(b)
+	// trace[d][k+d] is how far along a the furthest path with d edits
+	// reaches on diagonal k, which is x-y
+	var trace [][]int
+	for d := 0; d <= n+m; d++ {
+		if d > DIFF_LIMIT {
+			return nil
+		}
+		cur := make([]int, 2*d+1)
+		done := false
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if d > 0 {
+				prev := trace[d-1]
+				if k == -d || (k != d && prev[k-1+d-1] < prev[k+1+d-1]) {
+					x = prev[k+1+d-1]
+				} else {
+					x = prev[k-1+d-1] + 1
+				}
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			cur[k+d] = x
+			if x >= n && y >= m {
+				done = true
+				break
+			}
+		}
+		trace = append(trace, cur)
+		if done {
+			break
+		}
+	}
+
+	// walk back from the end, noting the lines passed along diagonals
+	match := make([]int, n)
+	for i := range match {
+		match[i] = -1
+	}
+	x, y := n, m
+	for d := len(trace) - 1; d > 0; d-- {
+		prev := trace[d-1]
+		k := x - y
+		var pk int
+		if k == -d || (k != d && prev[k-1+d-1] < prev[k+1+d-1]) {
+			pk = k + 1
+		} else {
+			pk = k - 1
+		}
+		px := prev[pk+d-1]
+		py := px - pk
+		// where the diagonal run at the end of this step starts
+		sx, sy := px, py+1
+		if pk == k-1 {
+			sx, sy = px+1, py
+		}
+		for x > sx && y > sy {
+			x--
+			y--
+			match[x] = y
+		}
+		x, y = px, py
+	}
+	for x > 0 && y > 0 {
+		x--
+		y--
+		match[x] = y
+	}
+	return match
+}
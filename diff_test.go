@@ -0,0 +1,154 @@
+package main
+
+import (
+	"math/rand"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// Turn a into b using the hunks, checking the lines outside them are the same
+func apply_hunks(t *testing.T, a []string, b []string, hunks []diff_hunk) []string {
+	t.Helper()
+	var out []string
+	i := 0
+	for _, h := range hunks {
+		if h.old_start < i || h.old_start-i != h.new_start-len(out) {
+			t.Fatalf("hunk %+v is out of order or misaligned in %v", h, hunks)
+		}
+		if h.old_count == 0 && h.new_count == 0 {
+			t.Fatalf("empty hunk %+v", h)
+		}
+		out = append(out, a[i:h.old_start]...)
+		out = append(out, b[h.new_start:h.new_start+h.new_count]...)
+		i = h.old_start + h.old_count
+	}
+	return append(out, a[i:]...)
+}
+
+// Make a file out of a few distinct lines so there are plenty of repeats to match
+func random_lines(r *rand.Rand, n int) []string {
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = strconv.Itoa(r.Intn(6))
+	}
+	return lines
+}
+
+// Edit some lines of a file at random
+func random_edit(r *rand.Rand, a []string) []string {
+	b := append([]string{}, a...)
+	for edits := r.Intn(6); edits > 0; edits-- {
+		at := r.Intn(len(b) + 1)
+		switch r.Intn(3) {
+		case 0:
+			b = append(b[:at], append([]string{"new " + strconv.Itoa(r.Intn(3))}, b[at:]...)...)
+		case 1:
+			if at < len(b) {
+				b = append(b[:at], b[at+1:]...)
+			}
+		case 2:
+			if at < len(b) {
+				b[at] = "changed"
+			}
+		}
+	}
+	return b
+}
+
+// Length of the longest common subsequence, the slow way
+func lcs_length(a []string, b []string) int {
+	table := make([][]int, len(a)+1)
+	for i := range table {
+		table[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				table[i][j] = table[i+1][j+1] + 1
+			case table[i+1][j] > table[i][j+1]:
+				table[i][j] = table[i+1][j]
+			default:
+				table[i][j] = table[i][j+1]
+			}
+		}
+	}
+	return table[0][0]
+}
+
+func TestDiffLinesRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for n := 0; n < 2000; n++ {
+		a := random_lines(r, r.Intn(30))
+		b := random_edit(r, a)
+		if n%4 == 0 {
+			b = random_lines(r, r.Intn(30))
+		}
+		hunks := diff_lines(a, b)
+		if got := apply_hunks(t, a, b, hunks); !reflect.DeepEqual(got, b) && len(got)+len(b) > 0 {
+			t.Fatalf("diff_lines(%q, %q) = %+v, which gives %q", a, b, hunks, got)
+		}
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		a, b []string
+		want []diff_hunk
+	}{
+		{nil, nil, nil},
+		{[]string{"a", "b"}, []string{"a", "b"}, nil},
+		{nil, []string{"a", "b"}, []diff_hunk{{0, 0, 0, 2}}},
+		{[]string{"a", "b"}, nil, []diff_hunk{{0, 2, 0, 0}}},
+		{[]string{"a", "b", "c"}, []string{"a", "x", "c"}, []diff_hunk{{1, 1, 1, 1}}},
+		{[]string{"a", "b", "c"}, []string{"a", "c"}, []diff_hunk{{1, 1, 1, 0}}},
+		{[]string{"a", "c"}, []string{"a", "b", "c"}, []diff_hunk{{1, 0, 1, 1}}},
+		{[]string{"a", "b", "c", "d", "e"}, []string{"x", "b", "c", "d", "y"}, []diff_hunk{{0, 1, 0, 1}, {4, 1, 4, 1}}},
+	}
+	for _, test := range tests {
+		if got := diff_lines(test.a, test.b); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("diff_lines(%q, %q) = %+v, want %+v", test.a, test.b, got, test.want)
+		}
+	}
+}
+
+func TestMatchLines(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	for n := 0; n < 500; n++ {
+		a := random_lines(r, r.Intn(20))
+		b := random_lines(r, r.Intn(20))
+		match := match_lines(a, b)
+		kept, last := 0, -1
+		for i, j := range match {
+			if j < 0 {
+				continue
+			}
+			if j <= last || a[i] != b[j] {
+				t.Fatalf("match_lines(%q, %q) = %v, which doesn't keep lines in order", a, b, match)
+			}
+			last = j
+			kept++
+		}
+		// the fewest edits keep as many lines as possible
+		if want := lcs_length(a, b); kept != want {
+			t.Fatalf("match_lines(%q, %q) kept %d lines, want %d", a, b, kept, want)
+		}
+	}
+}
+
+func TestMatchLinesLimit(t *testing.T) {
+	a := make([]string, DIFF_LIMIT)
+	b := make([]string, DIFF_LIMIT)
+	for i := range a {
+		a[i] = "a" + strconv.Itoa(i)
+		b[i] = "b" + strconv.Itoa(i)
+	}
+	if match := match_lines(a, b[:DIFF_LIMIT/2]); match != nil {
+		t.Error("match_lines went past DIFF_LIMIT edits")
+	}
+	// too different to diff properly is one change from the first difference to the last
+	if got, want := diff_lines(append([]string{"same"}, a...), append([]string{"same"}, b...)), []diff_hunk{{1, DIFF_LIMIT, 1, DIFF_LIMIT}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("diff_lines of very different files = %+v, want %+v", got, want)
+	}
+}
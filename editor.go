@@ -3,12 +3,14 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"editor/lsp"
 	"editor/syntax"
 	"editor/terminal_ctl"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 	"unicode"
@@ -23,8 +25,26 @@ const EMAIL = "acgoidel@gmail.com"
 
 // values for special keys
 const (
+	KEY_COMPLETE  = 0x00 // ctrl-space
+	KEY_AUTO_PAIR = 0x02
+	KEY_DEFINE    = 0x04
+	KEY_FIND      = 0x06
+	KEY_GOTO      = 0x07
+	KEY_OPEN      = 0x0F
+	KEY_COMMAND   = 0x10
+	KEY_TAB       = 0x09
+	KEY_HOVER     = 0x0B
+	KEY_LINE_NUMS = 0x0C
+	KEY_WORD_COMP = 0x0E
+	KEY_INDENT    = 0x14
 	KEY_QUIT      = 0x11
+	KEY_BACK      = 0x12
 	KEY_SAVE      = 0x13
+	KEY_WRAP      = 0x17
+	KEY_REDO      = 0x19
+	KEY_UNDO      = 0x1A
+	KEY_MATCH     = 0x1D
+	KEY_COMMENT   = 0x1F // ctrl-/
 	KEY_BACKSPACE = 0x7F
 	KEY_NEW_LINE  = 0x0D
 	KEY_LEFT      = 1000 + iota
@@ -34,8 +54,33 @@ const (
 	KEY_PG_UP     = 1000 + iota
 	KEY_PG_DOWN   = 1000 + iota
 	KEY_DEL       = 1000 + iota
+
+	KEY_SHIFT_LEFT  = 1000 + iota
+	KEY_SHIFT_RIGHT = 1000 + iota
+	KEY_SHIFT_UP    = 1000 + iota
+	KEY_SHIFT_DOWN  = 1000 + iota
+
+	KEY_HOME       = 1000 + iota
+	KEY_END        = 1000 + iota
+	KEY_CTRL_LEFT  = 1000 + iota
+	KEY_CTRL_RIGHT = 1000 + iota
+	KEY_CTRL_DEL   = 1000 + iota
+	KEY_DEL_WORD   = 1000 + iota // ctrl-backspace or alt-backspace
 )
 
+// keys that mean something else when shift or ctrl is held
+var shift_keys = map[uint]uint{
+	KEY_LEFT:  KEY_SHIFT_LEFT,
+	KEY_RIGHT: KEY_SHIFT_RIGHT,
+	KEY_UP:    KEY_SHIFT_UP,
+	KEY_DOWN:  KEY_SHIFT_DOWN,
+}
+var ctrl_keys = map[uint]uint{
+	KEY_LEFT:  KEY_CTRL_LEFT,
+	KEY_RIGHT: KEY_CTRL_RIGHT,
+	KEY_DEL:   KEY_CTRL_DEL,
+}
+
 const (
 	T_BOLD   byte = 1
 	T_RED    byte = 31
@@ -53,10 +98,31 @@ const (
 	H_NONE    byte = T_OFF
 	H_NUM     byte = T_BLUE
 	H_MATCH   byte = T_INVERT
+	H_SELECT  byte = T_INVERT
 	H_STR     byte = T_GREEN
 	H_COMMENT      = T_CYAN
 	H_KEY          = T_PURPLE
 	H_KEY_ALT      = T_YELLOW
+
+	// merge conflicts, which are drawn over any other highlighting
+	H_CONFLICT_MARK   = T_PURPLE
+	H_CONFLICT_OURS   = T_GREEN
+	H_CONFLICT_BASE   = T_CYAN
+	H_CONFLICT_THEIRS = T_BLUE
+)
+
+// line number modes for the gutter
+const (
+	LN_OFF byte = iota
+	LN_ABSOLUTE
+	LN_RELATIVE
+)
+
+// soft wrap modes
+const (
+	WRAP_OFF byte = iota
+	WRAP_CHAR
+	WRAP_WORD
 )
 
 type line_t struct {
@@ -70,27 +136,105 @@ type vector struct {
 	y uint
 }
 
-type editor_state struct {
-	default_term_state *terminal.State
+// A screen row of a wrapped line
+// start is the byte index and col the render column it begins at
+type segment struct {
+	start uint
+	col   uint
+}
 
+// Everything that belongs to one open file
+type buffer_t struct {
 	file_name string
 	new_file  bool
 
+	offset     vector
+	cursor     vector
+	offset_row uint // first wrapped row of the top line that is on screen
+
+	anchor    vector // where the selection started, the cursor is its other end
+	selecting bool
+
+	undo        []snapshot
+	redo        []snapshot
+	last_edit   byte
+	last_edit_y uint
+
+	tab_width  uint
+	expand_tab bool
+
+	used_rows uint
+	lines     []line_t
+
+	clean bool
+
+	// list buffers can't be edited, and enter runs on_enter for the cursor's row
+	read_only bool
+	list_kind string
+	on_enter  func(row uint)
+
+	language syntax.Syntax
+
+	// bumped on every change, so the language server can be told about them
+	version        int
+	synced_version int
+	lsp_open       bool
+	diagnostics    []lsp.Diagnostic
+
+	// the file's lines in the last commit, nil if it isn't in git, and
+	// how they differ from the buffer as of git_version
+	git_head    []string
+	git_hunks   []diff_hunk
+	git_version int
+
+	// who last changed each line, kept up to date while blaming is on
+	blaming       bool
+	blame_lines   []blame_line
+	blame_running bool
+	blame_version int
+
+	// merge conflicts as of conflict_version
+	conflicts        []conflict
+	conflict_version int
+}
+
+type editor_state struct {
+	default_term_state *terminal.State
+
+	// the current buffer is kept here while it is open and
+	// written back to its place in buffers when switching away
+	buffer_t
+	buffers []buffer_t
+	current int
+
 	msg         string
 	msg_time    time.Time
 	msg_timeout time.Duration
+	prompting   bool
+	prompt_x    uint // where the cursor is in the message bar while prompting
 
+	width  uint // full width of the terminal
+	gutter uint // columns taken up by line numbers and signs
+	signs  bool // whether the gutter has a column for marks next to lines
+	blame  bool // whether the gutter starts with who last changed each line
 	dim    vector
-	offset vector
-	cursor vector
 
-	used_rows uint
-	lines     []line_t
+	render_x uint // screen column of the cursor once tabs are expanded
+	render_y uint // screen row of the cursor
 
-	clean          bool
-	quit_attempted bool
+	match     vector // bracket matching the one at the cursor
+	has_match bool
 
-	language syntax.Syntax
+	// lines drawn over the text while picking from a list
+	overlay     []string
+	overlay_sel int
+
+	line_numbers   byte
+	wrap           byte
+	auto_pair      bool
+	format_on_save bool
+
+	quit_attempted bool
 }
 
 var editor editor_state
@@ -126,18 +270,39 @@ func del_from_buffer(b *buf, loc int) {
 	b.len--
 }
 
+// Move the cursor one character right, onto the next line from the end of one
+// Unlike move_cursor this doesn't end the current undo step, so deletes can use it
+func step_right() {
+	// only move right if this is not the end of a line
+	// or if there is a line below to move to
+	if editor.cursor.y >= editor.used_rows {
+		return
+	}
+	l := &editor.lines[editor.cursor.y]
+	if editor.cursor.x < l.len {
+		editor.cursor.x++
+	} else if editor.cursor.x == l.len && editor.cursor.y+1 < editor.used_rows {
+		editor.cursor.x = 0
+		editor.cursor.y++
+	}
+}
+
 // Logic for controlling the cursor
 func move_cursor(key uint) {
-	var l *line_t
+	end_edit()
 	switch key {
 	case KEY_UP:
-		// only move up if cursor is not on first line
-		if editor.cursor.y > 0 {
+		if editor.wrap != WRAP_OFF {
+			move_wrapped(true)
+		} else if editor.cursor.y > 0 {
+			// only move up if cursor is not on first line
 			editor.cursor.y--
 		}
 	case KEY_DOWN:
-		// only move down if we are above the first unused line
-		if editor.cursor.y+1 < editor.used_rows {
+		if editor.wrap != WRAP_OFF {
+			move_wrapped(false)
+		} else if editor.cursor.y+1 < editor.used_rows {
+			// only move down if we are above the first unused line
 			editor.cursor.y++
 		}
 	case KEY_LEFT:
@@ -150,23 +315,7 @@ func move_cursor(key uint) {
 			editor.cursor.x = editor.lines[editor.cursor.y].len
 		}
 	case KEY_RIGHT:
-		// only move right if this is not the end of a line
-		// or if there is a line below to move to
-		if editor.cursor.y < editor.used_rows {
-			l = &editor.lines[editor.cursor.y]
-		} else {
-			l = nil
-		}
-		if l != nil {
-			if editor.cursor.x < l.len {
-				editor.cursor.x++
-			} else if editor.cursor.x == l.len {
-				if editor.cursor.y+1 < editor.used_rows {
-					editor.cursor.x = 0
-					editor.cursor.y++
-				}
-			}
-		}
+		step_right()
 	}
 	var length uint = 0
 	if editor.cursor.y < editor.used_rows {
@@ -178,14 +327,248 @@ func move_cursor(key uint) {
 	}
 }
 
+// Recompute the gutter width from the number of lines
+// and shrink the text area so that it fits beside it
+func update_gutter() {
+	editor.gutter = 0
+	editor.blame = editor.blaming
+	if editor.blame {
+		editor.gutter += BLAME_WIDTH
+	}
+	editor.signs = has_signs()
+	if editor.signs {
+		editor.gutter++
+	}
+	if editor.line_numbers != LN_OFF {
+		digits := uint(len(strconv.Itoa(int(editor.used_rows))))
+		if digits < 3 {
+			digits = 3
+		}
+		editor.gutter += digits + 1
+	}
+	// never let the gutter swallow the whole screen
+	if editor.gutter >= editor.width {
+		editor.gutter = 0
+		editor.blame = false
+		editor.signs = false
+	}
+	editor.dim.x = editor.width - editor.gutter
+}
+
+// Cycle between no line numbers, absolute and relative line numbers
+func toggle_line_numbers() {
+	set_line_numbers((editor.line_numbers + 1) % byte(len(line_number_modes)))
+}
+
+// names of the line number modes, in the order of their values
+var line_number_modes = []string{"off", "absolute", "relative"}
+
+func set_line_numbers(mode byte) {
+	editor.line_numbers = mode
+	set_message("Line numbers: %s", line_number_modes[mode])
+}
+
+// Width of a character that starts at the given screen column
+func char_width(char byte, col uint) uint {
+	if char == '\t' {
+		return editor.tab_width - col%editor.tab_width
+	}
+	return 1
+}
+
+// Convert a byte index in a line to the column it is displayed at
+func to_render_x(line *line_t, x uint) uint {
+	var col uint
+	for i := uint(0); i < x && i < line.len; i++ {
+		col += char_width(line.text[i], col)
+	}
+	return col
+}
+
+// Split a line into the screen rows it takes up when soft wrap is on
+// In word mode rows are broken after a delimiter where possible
+func wrap_line(line *line_t) []segment {
+	segs := []segment{{0, 0}}
+	if editor.wrap == WRAP_OFF || editor.dim.x == 0 {
+		return segs
+	}
+
+	var col uint
+	var brk segment // where the last word in the current row ends
+	for i := uint(0); i < line.len; i++ {
+		char := line.text[i]
+		width := char_width(char, col)
+		start := segs[len(segs)-1]
+		if col+width-start.col > editor.dim.x && i > start.start {
+			if editor.wrap == WRAP_WORD && brk.start > start.start {
+				segs = append(segs, brk)
+			} else {
+				segs = append(segs, segment{i, col})
+			}
+		}
+		if is_delimiter(char) {
+			brk = segment{i + 1, col + width}
+		}
+		col += width
+	}
+	return segs
+}
+
+// Find which wrapped row of a line a byte index is displayed on
+func segment_of(segs []segment, x uint) uint {
+	var k uint
+	for k+1 < uint(len(segs)) && segs[k+1].start <= x {
+		k++
+	}
+	return k
+}
+
+// Wrapped rows of the given line, or a single empty row past the end
+func line_segments(row uint) []segment {
+	if row >= editor.used_rows {
+		return []segment{{0, 0}}
+	}
+	return wrap_line(&editor.lines[row])
+}
+
+// Move the cursor up or down one screen row when lines are wrapped
+// keeping it at the same column on screen where possible
+func move_wrapped(up bool) {
+	if editor.cursor.y >= editor.used_rows {
+		return
+	}
+	line := &editor.lines[editor.cursor.y]
+	segs := wrap_line(line)
+	k := segment_of(segs, editor.cursor.x)
+	col := to_render_x(line, editor.cursor.x) - segs[k].col
+
+	if up {
+		if k > 0 {
+			k--
+		} else if editor.cursor.y > 0 {
+			editor.cursor.y--
+			line = &editor.lines[editor.cursor.y]
+			segs = wrap_line(line)
+			k = uint(len(segs)) - 1
+		} else {
+			return
+		}
+	} else {
+		if k+1 < uint(len(segs)) {
+			k++
+		} else if editor.cursor.y+1 < editor.used_rows {
+			editor.cursor.y++
+			line = &editor.lines[editor.cursor.y]
+			segs = wrap_line(line)
+			k = 0
+		} else {
+			return
+		}
+	}
+
+	end := line.len
+	if k+1 < uint(len(segs)) {
+		end = segs[k+1].start
+	}
+	x, c := segs[k].start, segs[k].col
+	for x < end {
+		width := char_width(line.text[x], c)
+		if c+width > segs[k].col+col {
+			break
+		}
+		c += width
+		x++
+	}
+	// the end of a row that wraps belongs to the row below
+	if x == end && end != line.len && x > segs[k].start {
+		x--
+	}
+	editor.cursor.x = x
+}
+
+// Cycle soft wrap between off, wrapping anywhere and wrapping at words
+func toggle_wrap() {
+	set_wrap((editor.wrap + 1) % byte(len(wrap_modes)))
+}
+
+// names of the soft wrap modes, in the order of their values
+var wrap_modes = []string{"off", "on", "word"}
+
+func set_wrap(mode byte) {
+	editor.wrap = mode
+	editor.offset.x = 0
+	editor.offset_row = 0
+	set_message("Soft wrap: %s", wrap_modes[mode])
+}
+
+// Scrolling when lines are wrapped counts screen rows rather than lines
+func scroll_wrapped() {
+	editor.offset.x = 0
+
+	segs := line_segments(editor.cursor.y)
+	k := segment_of(segs, editor.cursor.x)
+	editor.render_x -= segs[k].col
+	if editor.render_x >= editor.dim.x && editor.dim.x > 0 {
+		editor.render_x = editor.dim.x - 1
+	}
+
+	if top := uint(len(line_segments(editor.offset.y))); editor.offset_row >= top {
+		editor.offset_row = top - 1
+	}
+
+	// cursor is above the top of the screen
+	if editor.cursor.y < editor.offset.y ||
+		(editor.cursor.y == editor.offset.y && k < editor.offset_row) {
+		editor.offset.y = editor.cursor.y
+		editor.offset_row = k
+	}
+
+	// walk back from the cursor to find the lowest the top row can be
+	y, j := editor.cursor.y, k
+	for rows := uint(1); rows < editor.dim.y; rows++ {
+		if j > 0 {
+			j--
+		} else if y > 0 {
+			y--
+			j = uint(len(line_segments(y))) - 1
+		} else {
+			break
+		}
+	}
+	if editor.offset.y < y || (editor.offset.y == y && editor.offset_row < j) {
+		editor.offset.y = y
+		editor.offset_row = j
+	}
+
+	// count the screen rows between the top and the cursor
+	editor.render_y = 0
+	for row := editor.offset.y; row < editor.cursor.y; row++ {
+		editor.render_y += uint(len(line_segments(row)))
+	}
+	editor.render_y = editor.render_y + k - editor.offset_row
+}
+
 // Update which row and column to start displaying at
 // so that the editor scrolls
 func scroll() {
-	if editor.cursor.x < editor.offset.x {
-		editor.offset.x = editor.cursor.x
+	update_gutter()
+
+	editor.render_x = 0
+	if editor.cursor.y < editor.used_rows {
+		editor.render_x = to_render_x(&editor.lines[editor.cursor.y], editor.cursor.x)
+	}
+
+	if editor.wrap != WRAP_OFF {
+		scroll_wrapped()
+		return
 	}
-	if editor.cursor.x >= editor.offset.x+editor.dim.x {
-		editor.offset.x = editor.cursor.x - editor.dim.x + 1
+	editor.offset_row = 0
+
+	if editor.render_x < editor.offset.x {
+		editor.offset.x = editor.render_x
+	}
+	if editor.render_x >= editor.offset.x+editor.dim.x {
+		editor.offset.x = editor.render_x - editor.dim.x + 1
 	}
 	if editor.cursor.y < editor.offset.y {
 		editor.offset.y = editor.cursor.y
@@ -193,12 +576,18 @@ func scroll() {
 	if editor.cursor.y >= editor.dim.y+editor.offset.y {
 		editor.offset.y = editor.cursor.y - editor.dim.y + 1
 	}
+	editor.render_y = editor.cursor.y - editor.offset.y
 }
 
 var delimiters []byte = []byte(",.()+-/*=~%<>[]; \t\n\r")
 
+// Languages can set their own delimiters, which also decide what a word is
 func is_delimiter(char byte) bool {
-	if bytes.IndexByte(delimiters, char) < 0 {
+	delims := delimiters
+	if editor.language.Delimiters != nil {
+		delims = editor.language.Delimiters
+	}
+	if bytes.IndexByte(delims, char) < 0 {
 		return false
 	}
 	return true
@@ -300,6 +689,403 @@ func highlight_line(line *line_t) {
 	}
 }
 
+// Whether a character is part of the code rather than a string or comment
+func is_code(line *line_t, i uint) bool {
+	if !editor.language.Is_highlighted || i >= uint(len(line.highlight)) {
+		return true
+	}
+	return line.highlight[i] != H_STR && line.highlight[i] != H_COMMENT
+}
+
+const openers = "([{"
+const closers = ")]}"
+
+// Find the bracket that matches the one at the given position
+// Brackets in strings and comments are skipped, and at most limit lines are searched
+func find_match(pos vector, limit uint) (vector, bool) {
+	if pos.y >= editor.used_rows || pos.x >= editor.lines[pos.y].len {
+		return pos, false
+	}
+	line := &editor.lines[pos.y]
+	char := line.text[pos.x]
+	if !is_code(line, pos.x) {
+		return pos, false
+	}
+
+	var open, close byte
+	forward := true
+	if i := strings.IndexByte(openers, char); i >= 0 {
+		open, close = char, closers[i]
+	} else if i := strings.IndexByte(closers, char); i >= 0 {
+		open, close = char, openers[i]
+		forward = false
+	} else {
+		return pos, false
+	}
+
+	depth := 0
+	x, y := int(pos.x), int(pos.y)
+	for lines := uint(0); lines < limit; lines++ {
+		line = &editor.lines[y]
+		for x >= 0 && x < int(line.len) {
+			if is_code(line, uint(x)) {
+				switch line.text[x] {
+				case open:
+					depth++
+				case close:
+					depth--
+				}
+				if depth == 0 {
+					return vector{uint(x), uint(y)}, true
+				}
+			}
+			if forward {
+				x++
+			} else {
+				x--
+			}
+		}
+
+		if forward {
+			y++
+			if y >= int(editor.used_rows) {
+				break
+			}
+			x = 0
+		} else {
+			y--
+			if y < 0 {
+				break
+			}
+			x = int(editor.lines[y].len) - 1
+		}
+	}
+	return pos, false
+}
+
+// Look for a bracket under the cursor, or just before it, and find its match
+func cursor_match(limit uint) (vector, bool) {
+	if match, ok := find_match(editor.cursor, limit); ok {
+		return match, ok
+	}
+	if editor.cursor.x > 0 {
+		return find_match(vector{editor.cursor.x - 1, editor.cursor.y}, limit)
+	}
+	return editor.cursor, false
+}
+
+// Move the cursor to the bracket matching the one it is on
+func jump_to_match() {
+	match, ok := cursor_match(editor.used_rows)
+	if !ok {
+		set_message("No matching bracket")
+		return
+	}
+	end_edit()
+	editor.cursor = match
+}
+
+// Whitespace, delimiters and the rest of the characters each make up words of their own
+func char_class(char byte) int {
+	if char == ' ' || char == '\t' {
+		return 0
+	}
+	if is_delimiter(char) {
+		return 1
+	}
+	return 2
+}
+
+// The bounds of the word the cursor is in or just after, equal if there isn't one
+func word_at_cursor() (uint, uint) {
+	if editor.cursor.y >= editor.used_rows {
+		return 0, 0
+	}
+	line := &editor.lines[editor.cursor.y]
+	start, end := editor.cursor.x, editor.cursor.x
+	for start > 0 && char_class(line.text[start-1]) == 2 {
+		start--
+	}
+	for end < line.len && char_class(line.text[end]) == 2 {
+		end++
+	}
+	return start, end
+}
+
+// The word the cursor is in or just after
+func cursor_word() string {
+	start, end := word_at_cursor()
+	if start == end {
+		return ""
+	}
+	return string(editor.lines[editor.cursor.y].text[start:end])
+}
+
+// Where the word before x on a line starts, skipping whitespace
+func word_start(line *line_t, x uint) uint {
+	for x > 0 && char_class(line.text[x-1]) == 0 {
+		x--
+	}
+	if x > 0 {
+		class := char_class(line.text[x-1])
+		for x > 0 && char_class(line.text[x-1]) == class {
+			x--
+		}
+	}
+	return x
+}
+
+// Where the word after x on a line ends, skipping whitespace
+func word_end(line *line_t, x uint) uint {
+	for x < line.len && char_class(line.text[x]) == 0 {
+		x++
+	}
+	if x < line.len {
+		class := char_class(line.text[x])
+		for x < line.len && char_class(line.text[x]) == class {
+			x++
+		}
+	}
+	return x
+}
+
+// Move the cursor by a word, going onto the next or previous line at either end
+func move_word(key uint) {
+	if editor.cursor.y >= editor.used_rows {
+		return
+	}
+	line := &editor.lines[editor.cursor.y]
+	if key == KEY_CTRL_LEFT {
+		if editor.cursor.x == 0 {
+			move_cursor(KEY_LEFT)
+			return
+		}
+		end_edit()
+		editor.cursor.x = word_start(line, editor.cursor.x)
+	} else {
+		if editor.cursor.x >= line.len {
+			move_cursor(KEY_RIGHT)
+			return
+		}
+		end_edit()
+		editor.cursor.x = word_end(line, editor.cursor.x)
+	}
+}
+
+// Go to the first non-blank character of the line,
+// or to the very start if the cursor is already there
+func move_home() {
+	end_edit()
+	if editor.cursor.y >= editor.used_rows {
+		return
+	}
+	indent := uint(len(leading_whitespace(editor.lines[editor.cursor.y].text)))
+	if editor.cursor.x == indent {
+		editor.cursor.x = 0
+	} else {
+		editor.cursor.x = indent
+	}
+}
+
+func move_end() {
+	end_edit()
+	if editor.cursor.y < editor.used_rows {
+		editor.cursor.x = editor.lines[editor.cursor.y].len
+	}
+}
+
+// Remove the bytes between two points on the cursor's line and leave the cursor at the first
+func delete_between(from uint, to uint) {
+	line := &editor.lines[editor.cursor.y]
+	line.text = append(line.text[:from], line.text[to:]...)
+	line.len = uint(len(line.text))
+	editor.cursor.x = from
+	highlight_line(line)
+	modified()
+}
+
+// Delete the word before the cursor, or join with the line above at its start
+func delete_word_back() {
+	if editor.cursor.y >= editor.used_rows || editor.cursor.x == 0 {
+		del()
+		return
+	}
+	line := &editor.lines[editor.cursor.y]
+	delete_between(word_start(line, editor.cursor.x), editor.cursor.x)
+}
+
+// Delete the word after the cursor, or join with the line below at its end
+func delete_word_forward() {
+	if editor.cursor.y >= editor.used_rows {
+		return
+	}
+	line := &editor.lines[editor.cursor.y]
+	if editor.cursor.x >= line.len {
+		if editor.cursor.y+1 < editor.used_rows {
+			step_right()
+			del()
+		}
+		return
+	}
+	delete_between(editor.cursor.x, word_end(line, editor.cursor.x))
+}
+
+// Start or extend the selection by moving the cursor
+func extend_selection(key uint) {
+	if !editor.selecting {
+		editor.anchor = editor.cursor
+		editor.selecting = true
+	}
+	switch key {
+	case KEY_SHIFT_LEFT:
+		move_cursor(KEY_LEFT)
+	case KEY_SHIFT_RIGHT:
+		move_cursor(KEY_RIGHT)
+	case KEY_SHIFT_UP:
+		move_cursor(KEY_UP)
+	case KEY_SHIFT_DOWN:
+		move_cursor(KEY_DOWN)
+	}
+}
+
+// The text between two positions, with lines joined by newlines
+func text_between(start vector, end vector) []byte {
+	if editor.used_rows == 0 {
+		return nil
+	}
+	if start.y == end.y {
+		return append([]byte{}, editor.lines[start.y].text[start.x:end.x]...)
+	}
+	text := append([]byte{}, editor.lines[start.y].text[start.x:]...)
+	for y := start.y + 1; y < end.y; y++ {
+		text = append(text, '\n')
+		text = append(text, editor.lines[y].text...)
+	}
+	text = append(text, '\n')
+	return append(text, editor.lines[end.y].text[:end.x]...)
+}
+
+// Replace the text between two positions with text that can span several lines
+// Returns the position just after the new text
+func replace_between(start vector, end vector, text []byte) vector {
+	if editor.used_rows == 0 {
+		add_line(0, nil)
+	}
+	head := editor.lines[start.y].text[:start.x]
+	tail := editor.lines[end.y].text[end.x:]
+
+	parts := bytes.Split(text, []byte("\n"))
+	rows := make([]line_t, len(parts))
+	for i, part := range parts {
+		rows[i].text = append([]byte{}, part...)
+	}
+	last := len(rows) - 1
+	after := vector{uint(len(rows[last].text)), start.y + uint(last)}
+	if last == 0 {
+		after.x += start.x
+	}
+	rows[0].text = append(append([]byte{}, head...), rows[0].text...)
+	rows[last].text = append(rows[last].text, tail...)
+
+	lines := append([]line_t{}, editor.lines[:start.y]...)
+	lines = append(lines, rows...)
+	editor.lines = append(lines, editor.lines[end.y+1:]...)
+	editor.used_rows = uint(len(editor.lines))
+	for y := start.y; y <= after.y; y++ {
+		editor.lines[y].len = uint(len(editor.lines[y].text))
+		highlight_line(&editor.lines[y])
+	}
+	modified()
+	return after
+}
+
+// Replace the rows from one up to another with whole lines, which can be none
+func replace_rows(from uint, to uint, lines []string) {
+	start, end := vector{0, from}, vector{0, to}
+	text := strings.Join(lines, "\n")
+	if len(lines) > 0 {
+		text += "\n"
+	}
+	if editor.used_rows == 0 {
+		end = start
+		text = strings.TrimSuffix(text, "\n")
+	} else if end.y >= editor.used_rows {
+		// at the end of the file there's no following line to end on, so the
+		// newline before the rows is the one that goes
+		last := editor.used_rows - 1
+		end = vector{editor.lines[last].len, last}
+		text = strings.TrimSuffix(text, "\n")
+		if start.y > 0 {
+			start = vector{editor.lines[start.y-1].len, start.y - 1}
+			if len(lines) > 0 {
+				text = "\n" + text
+			}
+		}
+	}
+	replace_between(start, end, []byte(text))
+}
+
+// The two ends of the selection, in the order they appear in the file
+func selection_bounds() (vector, vector) {
+	start, end := editor.anchor, editor.cursor
+	if end.y < start.y || (end.y == start.y && end.x < start.x) {
+		start, end = end, start
+	}
+	return start, end
+}
+
+func is_selected(row uint, x uint) bool {
+	if !editor.selecting {
+		return false
+	}
+	start, end := selection_bounds()
+	if row < start.y || row > end.y {
+		return false
+	}
+	if row == start.y && x < start.x {
+		return false
+	}
+	if row == end.y && x >= end.x {
+		return false
+	}
+	return true
+}
+
+// First and last rows that a command working on whole lines should act on
+// A selection that ends at the start of a line doesn't include that line
+func selected_rows() (uint, uint) {
+	if !editor.selecting {
+		y := editor.cursor.y
+		if y >= editor.used_rows && y > 0 {
+			y = editor.used_rows - 1
+		}
+		return y, y
+	}
+	start, end := selection_bounds()
+	if end.x == 0 && end.y > start.y {
+		end.y--
+	}
+	if end.y >= editor.used_rows && end.y > 0 {
+		end.y = editor.used_rows - 1
+	}
+	return start.y, end.y
+}
+
+// Keep the cursor and the selection anchor on the same characters
+// after old_len bytes at x on row y are replaced by new_len bytes
+func update_positions(y uint, x uint, old_len uint, new_len uint) {
+	for _, pos := range []*vector{&editor.cursor, &editor.anchor} {
+		if pos.y != y || pos.x < x {
+			continue
+		}
+		if pos.x >= x+old_len {
+			pos.x = pos.x + new_len - old_len
+		} else {
+			pos.x = x
+		}
+	}
+}
+
 // Set the status message and the time that it was set
 func set_message(args ...interface{}) {
 	editor.msg = fmt.Sprintf(args[0].(string), args[1:]...)
@@ -308,6 +1094,7 @@ func set_message(args ...interface{}) {
 
 // Mark file as modified and display status for how to save
 func modified() {
+	editor.version++
 	editor.clean = false
 	editor.quit_attempted = false
 	set_message("CTRL-S to save")
@@ -354,59 +1141,30 @@ func stringify(b *buf) {
 	b.len = len
 }
 
-func prompt(text string, callback func(*buf, uint)) string {
-	var in_buf buf = buf{}
-
-	for {
-		set_message(text, in_buf.buffer)
-		refresh_terminal()
-
-		char := read_input()
-
-		if char == KEY_DEL || char == KEY_BACKSPACE || char == 0x08 {
-			if in_buf.len > 0 {
-				del_from_buffer(&in_buf, len(in_buf.buffer)-1)
-			}
-		} else if char == '\r' {
-			if in_buf.len != 0 {
-				set_message("")
-				if callback != nil {
-					callback(&in_buf, char)
-				}
-				return string(in_buf.buffer)
-			}
-		} else if char == '\x1b' {
-			set_message("")
-			if callback != nil {
-				callback(&in_buf, char)
-			}
-			return ""
-		} else {
-			if unicode.IsPrint(rune(char)) {
-				add_to_buffer(&in_buf, string(char))
-			}
-		}
-		if callback != nil {
-			callback(&in_buf, char)
-		}
-	}
-}
-
 // Handles file saving logic
 // Checks if there is a filename, opens file, calls strigify and writes to file
 func save() {
+	if !writable() {
+		return
+	}
 	// is there a current filename
 	if editor.file_name == "" {
-		editor.file_name = prompt("Save as: %q", nil)
+		editor.file_name = expand_home(prompt("Save as: %s", &prompt_opts{
+			history:  "file",
+			complete: complete_file_name,
+		}))
 		if editor.file_name == "" {
 			set_message("Did not save")
 			return
 		}
-		editor.language = syntax.Setup_syntax(editor.file_name)
+		set_language(editor.file_name)
 		for i := 0; i < len(editor.lines); i++ {
 			highlight_line(&editor.lines[i])
 		}
 	}
+	if editor.format_on_save && !format_buffer() {
+		return
+	}
 	b := buf{}
 	stringify(&b) // get a buffer of the entire editor state
 
@@ -422,6 +1180,9 @@ func save() {
 	} else {
 		set_message("File saved. %d bytes written", b.len)
 		editor.clean = true
+		editor.new_file = false
+		document_saved()
+		load_git_head()
 	}
 }
 
@@ -448,25 +1209,318 @@ func insert(c byte) {
 	}
 	line.len++
 	editor.cursor.x++
+	auto_dedent()
 	highlight_line(line)
 	modified()
 }
 
+const pair_open = "([{\"'"
+const pair_close = ")]}\"'"
+
+// Whether text typed at a position would be code rather than in a string or comment
+// Highlights the text up to that point with a placeholder character appended
+func in_code_at(line *line_t, x uint) bool {
+	if !editor.language.Is_highlighted {
+		return true
+	}
+	var tmp line_t
+	tmp.text = append(append([]byte{}, line.text[:x]...), 'a')
+	tmp.len = x + 1
+	highlight_line(&tmp)
+	return is_code(&tmp, x)
+}
+
+func is_word_char(char byte) bool {
+	return char == '_' || unicode.IsLetter(rune(char)) || unicode.IsDigit(rune(char))
+}
+
+// Type a character, closing brackets and quotes when auto-pairing is on
+func type_char(c byte) {
+	if !editor.auto_pair || editor.cursor.y >= editor.used_rows {
+		insert(c)
+		return
+	}
+	line := &editor.lines[editor.cursor.y]
+	x := editor.cursor.x
+	var prev, next byte
+	if x > 0 {
+		prev = line.text[x-1]
+	}
+	if x < line.len {
+		next = line.text[x]
+	}
+	code := in_code_at(line, x)
+
+	// type over a closer that is already there
+	if next == c {
+		is_quote := c == '"' || c == '\''
+		is_closer := strings.IndexByte(closers, c) >= 0
+		if (is_quote && !code) || (is_closer && code) {
+			editor.cursor.x++
+			return
+		}
+	}
+
+	i := strings.IndexByte(pair_open, c)
+	if i < 0 || !code || is_word_char(next) {
+		insert(c)
+		return
+	}
+	// an apostrophe in a word or an escaped quote is not the start of a string
+	if (c == '"' || c == '\'') && (is_word_char(prev) || prev == '\\') {
+		insert(c)
+		return
+	}
+	insert(c)
+	insert(pair_close[i])
+	editor.cursor.x--
+}
+
+// Delete the character before the cursor, along with its closer if the pair is empty
+func backspace() {
+	if editor.auto_pair && editor.cursor.y < editor.used_rows {
+		line := &editor.lines[editor.cursor.y]
+		x := editor.cursor.x
+		if x > 0 && x < line.len {
+			i := strings.IndexByte(pair_open, line.text[x-1])
+			if i >= 0 && line.text[x] == pair_close[i] {
+				editor.cursor.x++
+				del()
+			}
+		}
+	}
+	del()
+}
+
+func toggle_auto_pair() {
+	set_auto_pair(!editor.auto_pair)
+}
+
+func set_auto_pair(on bool) {
+	editor.auto_pair = on
+	if on {
+		set_message("Auto-pairing: on")
+	} else {
+		set_message("Auto-pairing: off")
+	}
+}
+
+// Insert a tab, or spaces up to the next tab stop when expandtab is on
+func insert_tab() {
+	if !editor.expand_tab {
+		insert('\t')
+		return
+	}
+	var col uint
+	if editor.cursor.y < editor.used_rows {
+		col = to_render_x(&editor.lines[editor.cursor.y], editor.cursor.x)
+	}
+	for n := editor.tab_width - col%editor.tab_width; n > 0; n-- {
+		insert(' ')
+	}
+}
+
+// The whitespace at the start of some text
+func leading_whitespace(text []byte) []byte {
+	i := 0
+	for i < len(text) && (text[i] == ' ' || text[i] == '\t') {
+		i++
+	}
+	return text[:i]
+}
+
+// Number of screen columns taken up by some indentation
+func indent_width(indent []byte) uint {
+	var width uint
+	for _, char := range indent {
+		width += char_width(char, width)
+	}
+	return width
+}
+
+// Build indentation of the given width out of spaces, or tabs padded with spaces
+func make_indent(width uint, spaces bool) []byte {
+	if spaces {
+		return bytes.Repeat([]byte{' '}, int(width))
+	}
+	lead := bytes.Repeat([]byte{'\t'}, int(width/editor.tab_width))
+	return append(lead, bytes.Repeat([]byte{' '}, int(width%editor.tab_width))...)
+}
+
+// Rewrite the leading whitespace of a line using tabs or spaces
+// Returns true if the line was changed
+func retab_line(line *line_t, to_spaces bool) bool {
+	indent := uint(len(leading_whitespace(line.text)))
+	lead := make_indent(indent_width(line.text[:indent]), to_spaces)
+	if bytes.Equal(lead, line.text[:indent]) {
+		return false
+	}
+
+	line.text = append(lead, line.text[indent:]...)
+	line.len = uint(len(line.text))
+	highlight_line(line)
+	return true
+}
+
+// Convert the indentation of the whole file between tabs and spaces
+func retab(to_spaces bool) {
+	if !writable() {
+		return
+	}
+	begin_edit(EDIT_OTHER)
+	changed := 0
+	for i := range editor.lines {
+		line := &editor.lines[i]
+		old_len := line.len
+		if !retab_line(line, to_spaces) {
+			continue
+		}
+		changed++
+		// keep the cursor on the same character
+		if uint(i) == editor.cursor.y {
+			if editor.cursor.x+line.len >= old_len {
+				editor.cursor.x = editor.cursor.x + line.len - old_len
+			} else {
+				editor.cursor.x = 0
+			}
+		}
+	}
+	editor.expand_tab = to_spaces
+
+	kind := "tabs"
+	if to_spaces {
+		kind = "spaces"
+	}
+	if changed > 0 {
+		modified()
+	}
+	end_edit()
+	set_message("Converted %d lines to %s", changed, kind)
+}
+
+// Ask how indentation should be handled
+// Either convert the file to tabs or spaces, or set a new tab width
+func indent_settings() {
+	choice := prompt("Indent with (t)abs, (s)paces or set tab width: %s", nil)
+	switch choice {
+	case "":
+		return
+	case "t", "tabs":
+		retab(false)
+	case "s", "spaces":
+		retab(true)
+	default:
+		width, err := strconv.Atoi(choice)
+		if err != nil || width < 1 || width > 16 {
+			set_message("Invalid choice: %q", choice)
+			return
+		}
+		editor.tab_width = uint(width)
+		set_message("Tab width set to %d", width)
+	}
+}
+
+// Check whether a line ends with one of the language's tokens
+func ends_with_token(text []byte, tokens []string) bool {
+	text = bytes.TrimRight(text, " \t")
+	for _, tok := range tokens {
+		if bytes.HasSuffix(text, []byte(tok)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check whether a line starts with one of the language's tokens
+func starts_with_token(text []byte, tokens []string) bool {
+	text = bytes.TrimLeft(text, " \t")
+	for _, tok := range tokens {
+		if bytes.HasPrefix(text, []byte(tok)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Remove a level of indentation when a closing token is typed at the start of a line
+// Only dedents if the line is not already indented less than the one above it
+func auto_dedent() {
+	line := &editor.lines[editor.cursor.y]
+	indent := leading_whitespace(line.text)
+	if len(indent) == 0 || editor.cursor.x < uint(len(indent)) {
+		return
+	}
+
+	typed := string(line.text[len(indent):editor.cursor.x])
+	found := false
+	for _, tok := range editor.language.Dedent_on {
+		if typed == tok {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return
+	}
+
+	width := indent_width(indent)
+	for y := int(editor.cursor.y) - 1; y >= 0; y-- {
+		above := editor.lines[y].text
+		if len(bytes.TrimSpace(above)) == 0 {
+			continue
+		}
+		above_width := indent_width(leading_whitespace(above))
+		if width < above_width {
+			return
+		}
+		if width == above_width && ends_with_token(above, editor.language.Indent_after) {
+			return
+		}
+		break
+	}
+
+	lead := make_indent((width-1)/editor.tab_width*editor.tab_width, editor.expand_tab)
+	line.text = append(lead, line.text[len(indent):]...)
+	line.len = uint(len(line.text))
+	editor.cursor.x = editor.cursor.x + uint(len(lead)) - uint(len(indent))
+}
+
+// Split the line at the cursor, carrying the indentation over to the new line
+// An opening token before the cursor indents the new line one more level
 func new_line() {
 	if editor.cursor.x == 0 {
 		empty_line := make([]byte, 0)
 		add_line(editor.cursor.y, empty_line)
-	} else {
-		cur_line := editor.lines[editor.cursor.y]
-		back := cur_line.text[editor.cursor.x:]
-		add_line(editor.cursor.y+1, back)
+		editor.cursor.y++
+		return
+	}
 
-		front := cur_line.text[:editor.cursor.x]
-		editor.lines[editor.cursor.y].text = front
-		editor.lines[editor.cursor.y].len = uint(len(front))
+	cur_line := editor.lines[editor.cursor.y]
+	front := cur_line.text[:editor.cursor.x:editor.cursor.x]
+	back := bytes.TrimLeft(cur_line.text[editor.cursor.x:], " \t")
+
+	indent := leading_whitespace(front)
+	width := indent_width(indent)
+	opens := ends_with_token(front, editor.language.Indent_after)
+
+	lead := append([]byte{}, indent...)
+	if opens {
+		lead = make_indent(width+editor.tab_width, editor.expand_tab)
 	}
+	editor.lines[editor.cursor.y].text = front
+	editor.lines[editor.cursor.y].len = uint(len(front))
+	highlight_line(&editor.lines[editor.cursor.y])
+
+	// the closer of a pair that was just split goes on a line of its own
+	if opens && starts_with_token(back, editor.language.Dedent_on) {
+		closer := append(append([]byte{}, indent...), back...)
+		add_line(editor.cursor.y+1, closer)
+		back = nil
+	}
+	add_line(editor.cursor.y+1, append(lead, back...))
+
 	editor.cursor.y++
-	editor.cursor.x = 0
+	editor.cursor.x = uint(len(lead))
 }
 
 // Logic for deleting a character out of the editor
@@ -510,18 +1564,36 @@ func del() {
 	modified()
 }
 
+// Pick the syntax for a file name and take on its indentation settings
+func set_language(file_name string) {
+	editor.language = syntax.Setup_syntax(file_name)
+	if formatter, ok := formatters[editor.language.Language_id]; ok {
+		editor.language.Formatter = formatter
+	}
+	editor.tab_width = editor.language.Tab_width
+	editor.expand_tab = editor.language.Expand_tab
+}
+
 func open_file(file_name string) {
+	if err := load_file(file_name); err != nil {
+		kill("Couldn't open file: ", err)
+	}
+}
+
+// Read a file into the current buffer
+// A file that doesn't exist yet is marked as new
+func load_file(file_name string) error {
 	editor.file_name = file_name
 	fd, err := os.Open(file_name)
 
-	editor.language = syntax.Setup_syntax(file_name)
+	set_language(file_name)
 
 	if err != nil {
 		if os.IsNotExist(err) {
 			editor.new_file = true
-			return
+			return nil
 		} else {
-			kill("Couldn't open file: ", err)
+			return err
 		}
 	}
 	defer fd.Close()
@@ -537,10 +1609,14 @@ func open_file(file_name string) {
 		add_line(editor.used_rows, line)
 	}
 	if err != nil && err != io.EOF {
-		kill("Error while reading file: ", err)
+		return err
 	}
 
 	editor.clean = true
+	document_opened()
+	load_git_head()
+	find_conflicts()
+	return nil
 }
 
 func print_status(b *buf) {
@@ -558,10 +1634,14 @@ func print_status(b *buf) {
 		mod = "(modified)"
 	}
 
-	msg := fmt.Sprintf("%.20s%s", file_name, mod)
+	if len(editor.buffers) > 1 {
+		file_name = fmt.Sprintf("[%d/%d] %s", editor.current+1, len(editor.buffers), file_name)
+	}
+
+	msg := fmt.Sprintf("%.30s%s", file_name, mod)
 	msg_len := uint(len(msg))
-	if msg_len > editor.dim.x {
-		msg_len = editor.dim.x
+	if msg_len > editor.width {
+		msg_len = editor.width
 	}
 	add_to_buffer(b, msg[:msg_len])
 
@@ -572,11 +1652,11 @@ func print_status(b *buf) {
 
 	x := editor.cursor.x + 1
 
-	loc_msg := fmt.Sprintf("row: %d, col: %d", y, x)
+	loc_msg := fmt.Sprintf("%srow: %d, col: %d", quickfix_status(), y, x)
 	loc_msg_len := uint(len(loc_msg))
 
-	for msg_len < editor.dim.x {
-		if editor.dim.x-msg_len == loc_msg_len {
+	for msg_len < editor.width {
+		if editor.width-msg_len == loc_msg_len {
 			add_to_buffer(b, loc_msg)
 			break
 		} else {
@@ -593,12 +1673,12 @@ func print_message(b *buf) {
 	add_to_buffer(b, "\x1b[K")
 
 	len := uint(len(editor.msg))
-	if len > editor.dim.x {
-		len = editor.dim.x
+	if len > editor.width {
+		len = editor.width
 	}
 	elapsed := (time.Now().Sub(editor.msg_time))
 	if len > 0 && elapsed < editor.msg_timeout {
-		add_to_buffer(b, editor.msg)
+		add_to_buffer(b, editor.msg[:len])
 	}
 
 }
@@ -640,43 +1720,166 @@ func print_welcome(b *buf) {
 	center_msg(b, byline, msg_len)
 }
 
+// Whether anything is marked next to the lines of the buffer
+func has_signs() bool {
+	return len(editor.diagnostics) > 0 || len(editor.git_hunks) > 0
+}
+
+// What is marked next to a line, and in what colour
+// Problems the language server found are shown over changes
+func line_sign(row uint) (byte, byte) {
+	if char, color := diagnostic_sign(row); char != 0 {
+		return char, color
+	}
+	return git_sign(row)
+}
+
+func draw_sign(b *buf, row uint) {
+	char, color := line_sign(row)
+	if char == 0 || row >= editor.used_rows {
+		add_to_buffer(b, " ")
+		return
+	}
+	add_to_buffer(b, fmt.Sprintf("\x1b[%dm%c\x1b[m", color, char))
+}
+
+// Draw the line number for the given row, padded to the gutter width
+// In relative mode the cursor line shows its absolute number
+func draw_gutter(b *buf, row uint) {
+	numbers := editor.gutter
+	if editor.blame {
+		draw_blame(b, row)
+		numbers -= BLAME_WIDTH
+	}
+	if editor.signs {
+		draw_sign(b, row)
+		numbers--
+	}
+	if numbers == 0 {
+		return
+	}
+	width := int(numbers - 1)
+	if row >= editor.used_rows {
+		add_to_buffer(b, strings.Repeat(" ", int(numbers)))
+		return
+	}
+
+	if row == editor.cursor.y {
+		add_to_buffer(b, fmt.Sprintf("\x1b[%dm", T_BOLD))
+		if editor.line_numbers == LN_RELATIVE {
+			add_to_buffer(b, fmt.Sprintf("%-*d ", width, row+1))
+		} else {
+			add_to_buffer(b, fmt.Sprintf("%*d ", width, row+1))
+		}
+		add_to_buffer(b, "\x1b[m")
+		return
+	}
+
+	num := row + 1
+	if editor.line_numbers == LN_RELATIVE {
+		if row > editor.cursor.y {
+			num = row - editor.cursor.y
+		} else {
+			num = editor.cursor.y - row
+		}
+	}
+	add_to_buffer(b, fmt.Sprintf("\x1b[%dm%*d \x1b[m", T_YELLOW, width, num))
+}
+
+// Draw the part of a line that falls between two render columns
+func draw_line(b *buf, row uint, from uint, to uint) {
+	line := &editor.lines[row]
+	current_highlight := H_NONE
+	var col uint
+	conflict := conflict_color(row)
+
+	for i, char := range line.text {
+		width := char_width(char, col)
+		// skip anything scrolled off to the left
+		if col+width <= from {
+			col += width
+			continue
+		}
+		if col >= to {
+			break
+		}
+		color := H_NONE
+		if editor.language.Is_highlighted {
+			color = line.highlight[i]
+		}
+		if conflict != H_NONE {
+			color = conflict
+		}
+		if editor.has_match && editor.match.y == row && editor.match.x == uint(i) {
+			color = H_MATCH
+		}
+		if is_selected(row, uint(i)) {
+			color = H_SELECT
+		}
+		if current_highlight != color {
+			// inverting is an attribute rather than a colour, so turn it off first
+			if current_highlight == T_INVERT {
+				add_to_buffer(b, "\x1b[m")
+			}
+			current_highlight = color
+			esc := fmt.Sprintf("\x1b[%dm", color)
+			add_to_buffer(b, esc)
+		}
+		if char == '\t' {
+			// expand the tab, cutting it at either edge of the screen
+			for c := col; c < col+width && c < to; c++ {
+				if c >= from {
+					add_to_buffer(b, " ")
+				}
+			}
+		} else {
+			add_to_buffer(b, string(char))
+		}
+		col += width
+	}
+	add_to_buffer(b, "\x1b[m")
+}
+
 func draw_rows(b *buf) {
+	if editor.overlay != nil {
+		draw_overlay(b)
+		return
+	}
+	update_conflicts()
+	row, k := editor.offset.y, editor.offset_row
+	segs := line_segments(row)
+
 	var start_row uint
 	for start_row = 0; start_row < editor.dim.y; start_row++ {
-		row := start_row + editor.offset.y
 		if row >= editor.used_rows {
-			if editor.used_rows == 0 && start_row == editor.dim.y/4 {
+			draw_gutter(b, row)
+			if editor.used_rows == 0 && !editor.read_only && start_row == editor.dim.y/4 {
 				print_welcome(b)
 				start_row += 2
 			} else {
 				add_to_buffer(b, "~")
 			}
+			row++
 		} else {
-			var len uint
-			if editor.offset.x >= editor.lines[row].len {
-				len = 0
+			// only the first row of a wrapped line gets a number
+			if k == 0 {
+				draw_gutter(b, row)
 			} else {
-				len = editor.lines[row].len - editor.offset.x
-				if len > editor.dim.x {
-					len = editor.dim.x
-				}
-				cutoff := len + editor.offset.x
-				line_highlights := editor.lines[row].highlight[editor.offset.x:cutoff]
-				current_highlight := H_NONE
-
-				for i, char := range editor.lines[row].text[editor.offset.x:cutoff] {
-					if editor.language.Is_highlighted {
-						color := line_highlights[i]
-
-						if current_highlight != color {
-							current_highlight = color
-							esc := fmt.Sprintf("\x1b[%dm", color)
-							add_to_buffer(b, esc)
-						}
-					}
-					add_to_buffer(b, string(char))
-				}
-				add_to_buffer(b, "\x1b[m")
+				add_to_buffer(b, strings.Repeat(" ", int(editor.gutter)))
+			}
+
+			from := editor.offset.x + segs[k].col
+			to := from + editor.dim.x
+			if k+1 < uint(len(segs)) && segs[k+1].col < to {
+				to = segs[k+1].col
+			}
+			draw_line(b, row, from, to)
+
+			k++
+			if k >= uint(len(segs)) {
+				row++
+				k = 0
+				segs = line_segments(row)
 			}
 		}
 		add_to_buffer(b, "\x1b[K")
@@ -684,8 +1887,39 @@ func draw_rows(b *buf) {
 	}
 }
 
+// Draw the list being picked from in place of the text
+// The first row counts the matches and the chosen line is highlighted
+func draw_overlay(b *buf) {
+	rows := int(editor.dim.y) - 1
+	top := 0
+	if editor.overlay_sel >= rows {
+		top = editor.overlay_sel - rows + 1
+	}
+	for i := -1; i < rows; i++ {
+		line := ""
+		switch {
+		case i < 0:
+			line = fmt.Sprintf("  %d matches", len(editor.overlay))
+		case top+i < len(editor.overlay):
+			line = "  " + editor.overlay[top+i]
+		}
+		if len(line) > int(editor.width) {
+			line = line[:editor.width]
+		}
+		if i >= 0 && top+i == editor.overlay_sel {
+			add_to_buffer(b, fmt.Sprintf("\x1b[%dm%s\x1b[m", H_SELECT, line))
+		} else {
+			add_to_buffer(b, line)
+		}
+		add_to_buffer(b, "\x1b[K")
+		add_to_buffer(b, "\r\n")
+	}
+}
+
 func refresh_terminal() {
 	scroll()
+	// only look as far as could be on screen
+	editor.match, editor.has_match = cursor_match(editor.dim.y)
 
 	var b buf = buf{}
 
@@ -697,8 +1931,11 @@ func refresh_terminal() {
 	print_message(&b)
 
 	cursor := fmt.Sprintf("\x1b[%d;%dH",
-		editor.cursor.y-editor.offset.y+1,
-		editor.cursor.x-editor.offset.x+1)
+		editor.render_y+1,
+		editor.render_x-editor.offset.x+editor.gutter+1)
+	if editor.prompting {
+		cursor = fmt.Sprintf("\x1b[%d;%dH", editor.dim.y+2, editor.prompt_x+1)
+	}
 	add_to_buffer(&b, cursor)
 	add_to_buffer(&b, "\x1b[?25h")
 
@@ -709,7 +1946,8 @@ func refresh_terminal() {
 	}
 }
 
-func read_input() uint {
+// Wait for a key from the terminal and work out which it is
+func read_key() uint {
 	var c [1]byte
 	var in int
 
@@ -720,28 +1958,36 @@ func read_input() uint {
 	if err != nil {
 		kill("Couldn't read from terminal", err)
 	}
+	// some terminals send ctrl-h for backspace, and most send it for
+	// ctrl-backspace too, so that can only be told apart when the terminal
+	// reports it as an escape sequence, otherwise alt-backspace deletes words
+	if c[0] == 0x08 {
+		return KEY_BACKSPACE
+	}
 	if c[0] == '\x1b' {
 		var sequence [2]byte
 
 		if in, err = os.Stdin.Read(sequence[:]); in != 2 {
+			if in == 1 && sequence[0] == KEY_BACKSPACE {
+				return KEY_DEL_WORD
+			}
 			return '\x1b'
 		}
 
 		if sequence[0] == 0x5B {
-			if sequence[1] >= 0x30 && sequence[1] < 0x39 {
-				if in, err = os.Stdin.Read(c[:]); in != 1 {
-					return '\x1b'
-				}
-				if c[0] == 0x7E {
-					switch sequence[1] {
-					case 0x33:
-						return KEY_DEL
-					case 0x35:
-						return KEY_PG_UP
-					case 0x36:
-						return KEY_PG_DOWN
+			if sequence[1] >= 0x30 && sequence[1] <= 0x39 {
+				// read parameters up to the final byte of the sequence
+				params := []byte{sequence[1]}
+				for {
+					if in, err = os.Stdin.Read(c[:]); in != 1 {
+						return '\x1b'
+					}
+					if c[0] >= 0x40 && c[0] <= 0x7E {
+						break
 					}
+					params = append(params, c[0])
 				}
+				return csi_key(string(params), c[0])
 			}
 
 			switch sequence[1] {
@@ -753,6 +1999,17 @@ func read_input() uint {
 				return KEY_RIGHT
 			case 0x44:
 				return KEY_LEFT
+			case 0x48:
+				return KEY_HOME
+			case 0x46:
+				return KEY_END
+			}
+		} else if sequence[0] == 0x4F {
+			switch sequence[1] {
+			case 0x48:
+				return KEY_HOME
+			case 0x46:
+				return KEY_END
 			}
 		}
 		return '\x1b'
@@ -760,32 +2017,208 @@ func read_input() uint {
 	return uint(c[0])
 }
 
+// Decode a control sequence that has parameters, like ESC[3~ or ESC[1;2A
+func csi_key(params string, final byte) uint {
+	fields := strings.Split(params, ";")
+	modifier := ""
+	if len(fields) > 1 {
+		modifier = fields[1]
+	}
+
+	// terminals that report modifiers on any key send ctrl-backspace
+	// as ESC[127;5u or, with xterm's modifyOtherKeys, ESC[27;5;127~
+	code := ""
+	switch {
+	case final == 'u':
+		code = fields[0]
+	case final == 0x7E && fields[0] == "27" && len(fields) == 3:
+		code = fields[2]
+	}
+	if code == "127" || code == "8" {
+		if modifier == "5" {
+			return KEY_DEL_WORD
+		}
+		return KEY_BACKSPACE
+	}
+	if code != "" {
+		return '\x1b'
+	}
+
+	var key uint
+	if final == 0x7E {
+		switch fields[0] {
+		case "1", "7":
+			key = KEY_HOME
+		case "3":
+			key = KEY_DEL
+		case "4", "8":
+			key = KEY_END
+		case "5":
+			key = KEY_PG_UP
+		case "6":
+			key = KEY_PG_DOWN
+		default:
+			return '\x1b'
+		}
+	} else {
+		switch final {
+		case 0x41:
+			key = KEY_UP
+		case 0x42:
+			key = KEY_DOWN
+		case 0x43:
+			key = KEY_RIGHT
+		case 0x44:
+			key = KEY_LEFT
+		case 0x48:
+			key = KEY_HOME
+		case 0x46:
+			key = KEY_END
+		default:
+			return '\x1b'
+		}
+	}
+
+	// modifier 2 is shift and 5 is ctrl
+	switch modifier {
+	case "2":
+		if k, ok := shift_keys[key]; ok {
+			return k
+		}
+	case "5":
+		if k, ok := ctrl_keys[key]; ok {
+			return k
+		}
+	}
+	return key
+}
+
+// Leave the editor, as long as there is nothing unsaved or it is forced
+func quit(force bool) {
+	unsaved := unsaved_buffers()
+	if unsaved == 0 || editor.quit_attempted || force {
+		io.WriteString(os.Stdout, "\x1b[2J")
+		io.WriteString(os.Stdout, "\x1b[H")
+		terminal_ctl.Disable_Raw(editor.default_term_state)
+		stop_language_servers()
+		os.Exit(0)
+	} else if unsaved == 1 {
+		set_message("There are unsaved changes, press CTRL-Q again to force quit.")
+		editor.quit_attempted = true
+	} else {
+		set_message("There are unsaved changes in %d buffers, press CTRL-Q again to force quit.", unsaved)
+		editor.quit_attempted = true
+	}
+}
+
+// Keys that change the contents of a buffer
+func is_edit_key(c uint) bool {
+	switch c {
+	case KEY_TAB, KEY_NEW_LINE, KEY_BACKSPACE, KEY_DEL, KEY_DEL_WORD, KEY_CTRL_DEL,
+		KEY_COMMENT, KEY_UNDO, KEY_REDO, KEY_COMPLETE, KEY_WORD_COMP:
+		return true
+	}
+	return c >= 0x20 && c <= 0xFF
+}
+
 func handle_key_event() {
 	c := read_input()
 
+	// list buffers only respond to enter, and can't be edited
+	if editor.read_only && c == KEY_NEW_LINE {
+		if editor.on_enter != nil && editor.cursor.y < editor.used_rows {
+			editor.on_enter(editor.cursor.y)
+		}
+		return
+	}
+	if !writable() && is_edit_key(c) {
+		return
+	}
+
+	if replace_placeholder(c) {
+		return
+	}
+
+	// anything besides extending the selection or acting on it clears it
+	switch c {
+	case KEY_SHIFT_LEFT, KEY_SHIFT_RIGHT, KEY_SHIFT_UP, KEY_SHIFT_DOWN, KEY_COMMENT, KEY_COMMAND:
+	default:
+		editor.selecting = false
+	}
+
 	switch c {
 	case KEY_QUIT:
-		if editor.clean || editor.quit_attempted {
-			io.WriteString(os.Stdout, "\x1b[2J")
-			io.WriteString(os.Stdout, "\x1b[H")
-			terminal_ctl.Disable_Raw(editor.default_term_state)
-			os.Exit(0)
-		} else if !editor.clean {
-			set_message("There are unsaved changes, press CTRL-Q again to force quit.")
-			editor.quit_attempted = true
-		}
+		quit(false)
 	case KEY_SAVE:
 		save()
+	case KEY_LINE_NUMS:
+		toggle_line_numbers()
+	case KEY_WRAP:
+		toggle_wrap()
+	case KEY_MATCH:
+		jump_to_match()
+	case KEY_GOTO:
+		goto_prompt()
+	case KEY_COMMAND:
+		command_prompt()
+	case KEY_OPEN:
+		open_prompt()
+	case KEY_FIND:
+		find_file()
+	case KEY_DEFINE:
+		go_to_definition()
+	case KEY_BACK:
+		jump_back()
+	case KEY_HOVER:
+		hover()
+	case KEY_COMPLETE:
+		lsp_complete()
+	case KEY_WORD_COMP:
+		complete_word()
+	case KEY_AUTO_PAIR:
+		toggle_auto_pair()
+	case KEY_INDENT:
+		indent_settings()
+	case KEY_COMMENT:
+		toggle_comment()
+	case KEY_UNDO:
+		undo()
+	case KEY_REDO:
+		redo()
+	case KEY_TAB:
+		if next_snippet_stop() || expand_snippet() {
+			break
+		}
+		begin_edit(EDIT_TYPE)
+		insert_tab()
 	case KEY_NEW_LINE:
+		begin_edit(EDIT_OTHER)
 		new_line()
 	case KEY_DEL:
-		move_cursor(KEY_RIGHT)
-		del()
-	case KEY_BACKSPACE, 0x08: // ctrl-h
+		begin_edit(EDIT_DELETE)
+		step_right()
 		del()
+	case KEY_BACKSPACE:
+		begin_edit(EDIT_DELETE)
+		backspace()
+	case KEY_DEL_WORD:
+		begin_edit(EDIT_DELETE)
+		delete_word_back()
+	case KEY_CTRL_DEL:
+		begin_edit(EDIT_DELETE)
+		delete_word_forward()
+
+	case KEY_CTRL_LEFT, KEY_CTRL_RIGHT:
+		move_word(c)
+	case KEY_HOME:
+		move_home()
+	case KEY_END:
+		move_end()
 
 	case KEY_UP, KEY_DOWN, KEY_LEFT, KEY_RIGHT:
 		move_cursor(c)
+	case KEY_SHIFT_LEFT, KEY_SHIFT_RIGHT, KEY_SHIFT_UP, KEY_SHIFT_DOWN:
+		extend_selection(c)
 
 	case KEY_PG_UP, KEY_PG_DOWN:
 		{
@@ -804,17 +2237,27 @@ func handle_key_event() {
 		break
 
 	default:
-		insert(byte(c))
+		// anything else is typed, control characters included,
+		// so list buffers have to turn it away here
+		if editor.read_only {
+			break
+		}
+		begin_edit(EDIT_TYPE)
+		type_char(byte(c))
 	}
 }
 
 func setup() {
-	editor.clean = true
+	editor.buffer_t = empty_buffer()
+	editor.buffers = []buffer_t{editor.buffer_t}
 
-	editor.dim.x, editor.dim.y = terminal_ctl.Size()
+	editor.width, editor.dim.y = terminal_ctl.Size()
+	editor.dim.x = editor.width
 	editor.dim.y -= 2
 
 	editor.msg_timeout = time.Second * 5
+	editor.auto_pair = true
+	editor.format_on_save = true
 }
 func main() {
 	editor.default_term_state = terminal_ctl.Enable_Raw()
@@ -822,15 +2265,31 @@ func main() {
 	setup()
 
 	if len(os.Args) > 1 {
-		open_file(os.Args[1])
+		file_name, location := split_location(os.Args[1])
+		if info, err := os.Stat(file_name); err == nil && info.IsDir() {
+			open_directory(file_name)
+		} else {
+			open_file(file_name)
+		}
+		if location != "" {
+			goto_location(location)
+		}
 	} else {
 		editor.new_file = true
 	}
 
-	set_message("CTRL-Q to quit")
+	if !report_conflicts() {
+		set_message("CTRL-Q to quit")
+	}
 
+	go read_keys()
 	for {
 		refresh_terminal()
 		handle_key_event()
+		run_after_prompt()
+		update_git_hunks()
+		sync_document()
+		show_line_diagnostic()
+		update_blame()
 	}
 }
@@ -0,0 +1,71 @@
+package main
+
+import "testing"
+
+func TestCsiKey(t *testing.T) {
+	tests := []struct {
+		params string
+		final  byte
+		want   uint
+	}{
+		{"3", '~', KEY_DEL},
+		{"3;5", '~', KEY_CTRL_DEL},
+		{"1;5", 'C', KEY_CTRL_RIGHT},
+		{"1;2", 'D', KEY_SHIFT_LEFT},
+		{"127;5", 'u', KEY_DEL_WORD},
+		{"127", 'u', KEY_BACKSPACE},
+		{"27;5;127", '~', KEY_DEL_WORD},
+		{"27;5;8", '~', KEY_DEL_WORD},
+		{"27;1;127", '~', KEY_BACKSPACE},
+		{"97;5", 'u', '\x1b'},
+		{"27;5;97", '~', '\x1b'},
+		{"9", '~', '\x1b'},
+	}
+	for _, test := range tests {
+		if got := csi_key(test.params, test.final); got != test.want {
+			t.Errorf("csi_key(%q, %q) = %d, want %d", test.params, test.final, got, test.want)
+		}
+	}
+}
+
+func TestDeleteWord(t *testing.T) {
+	saved := editor.buffer_t
+	defer func() { editor.buffer_t = saved }()
+
+	tests := []struct {
+		key    uint
+		text   string
+		cursor vector
+		want   string
+		after  vector
+	}{
+		{KEY_DEL_WORD, "foo bar", vector{7, 0}, "foo ", vector{4, 0}},
+		{KEY_DEL_WORD, "foo bar  ", vector{9, 0}, "foo ", vector{4, 0}},
+		{KEY_DEL_WORD, "x = foo.bar", vector{8, 0}, "x = foobar", vector{7, 0}},
+		{KEY_DEL_WORD, "foo bar", vector{5, 0}, "foo ar", vector{4, 0}},
+		{KEY_DEL_WORD, "one\ntwo", vector{0, 1}, "onetwo", vector{3, 0}},
+		{KEY_DEL_WORD, "one", vector{0, 0}, "one", vector{0, 0}},
+		{KEY_CTRL_DEL, "foo bar", vector{0, 0}, " bar", vector{0, 0}},
+		{KEY_CTRL_DEL, "foo  bar", vector{3, 0}, "foo", vector{3, 0}},
+		{KEY_CTRL_DEL, "one\ntwo", vector{3, 0}, "onetwo", vector{3, 0}},
+		{KEY_CTRL_DEL, "one", vector{3, 0}, "one", vector{3, 0}},
+	}
+	for _, test := range tests {
+		set_buffer_text(test.text)
+		editor.cursor = test.cursor
+		press(test.key)
+		if got := buffer_lines(); got != test.want || editor.cursor != test.after {
+			t.Errorf("key %d in %q at %v gave %q at %v, want %q at %v",
+				test.key, test.text, test.cursor, got, editor.cursor, test.want, test.after)
+		}
+	}
+
+	// deleting word after word is one undo step
+	set_buffer_text("one two three")
+	editor.cursor = vector{13, 0}
+	press(KEY_DEL_WORD, KEY_DEL_WORD)
+	undo()
+	if got := buffer_lines(); got != "one two three" {
+		t.Errorf("undoing two word deletes gave %q", got)
+	}
+}
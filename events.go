@@ -0,0 +1,56 @@
+package main
+
+// Keys are read from the terminal on their own goroutine so that work done in
+// the background can report back while the editor waits for input
+// Anything that touches the editor is sent to the main loop as an event
+var keys = make(chan uint)
+var events = make(chan func(), 64)
+
+func read_keys() {
+	for {
+		keys <- read_key()
+	}
+}
+
+// Have the main loop run something, for goroutines with results to show
+func post_event(event func()) {
+	events <- event
+}
+
+// events held back until the prompt that was open when they came in is answered
+var after_prompt []func()
+
+// Have the main loop run something once no prompt is open, for results that
+// ask the user something themselves or switch buffers, which would pull the
+// rug out from under a prompt that is already waiting for an answer
+func post_after_prompt(event func()) {
+	post_event(func() {
+		if editor.prompting {
+			after_prompt = append(after_prompt, event)
+			return
+		}
+		event()
+	})
+}
+
+// Run the events that were waiting for a prompt, now that there isn't one
+func run_after_prompt() {
+	for len(after_prompt) > 0 && !editor.prompting {
+		event := after_prompt[0]
+		after_prompt = after_prompt[1:]
+		event()
+	}
+}
+
+// Wait for the next key, running any events that come in before it
+func read_input() uint {
+	for {
+		select {
+		case key := <-keys:
+			return key
+		case event := <-events:
+			event()
+			refresh_terminal()
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestPostAfterPrompt(t *testing.T) {
+	defer func() { editor.prompting = false }()
+
+	ran := 0
+	editor.prompting = true
+	post_after_prompt(func() { ran++ })
+	(<-events)()
+	run_after_prompt()
+	if ran != 0 {
+		t.Fatal("an event ran while a prompt was open")
+	}
+
+	editor.prompting = false
+	run_after_prompt()
+	if ran != 1 || len(after_prompt) != 0 {
+		t.Errorf("after the prompt the event ran %d times with %d left waiting", ran, len(after_prompt))
+	}
+
+	// with no prompt open it runs straight away
+	post_after_prompt(func() { ran++ })
+	(<-events)()
+	if ran != 2 {
+		t.Error("an event waited with no prompt open")
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Run text through a shell command, returning what it wrote to stdout
+// and anything it wrote to stderr
+func run_filter(cmd string, input []byte) ([]byte, string, error) {
+	c := exec.Command("sh", "-c", cmd)
+	c.Stdin = bytes.NewReader(input)
+	var stdout, stderr bytes.Buffer
+	c.Stdout = &stdout
+	c.Stderr = &stderr
+	err := c.Run()
+
+	said := last_said(stderr.String())
+	if err != nil && said != "" {
+		err = fmt.Errorf("%s (%s)", said, err)
+	}
+	return stdout.Bytes(), said, err
+}
+
+// The last line a command wrote, which is usually the reason it failed
+func last_said(output string) string {
+	said := strings.TrimSpace(output)
+	return said[strings.LastIndexByte(said, '\n')+1:]
+}
+
+// Replace the selection, or the whole buffer if nothing is selected,
+// with the output of a shell command given it as input
+// The buffer is left alone if the command fails
+func filter(cmd string) {
+	if !writable() {
+		return
+	}
+	whole := !editor.selecting
+	var start, end vector
+	if editor.selecting {
+		start, end = selection_bounds()
+	} else if editor.used_rows > 0 {
+		last := editor.used_rows - 1
+		end = vector{editor.lines[last].len, last}
+	}
+
+	input := text_between(start, end)
+	if whole && editor.used_rows > 0 {
+		input = append(input, '\n')
+	}
+	out, said, err := run_filter(cmd, input)
+	if err != nil {
+		set_message("%s: %s", cmd, err)
+		return
+	}
+	// commands end their output with a newline whether or not there was one going in
+	if whole || !bytes.HasSuffix(input, []byte("\n")) {
+		out = bytes.TrimSuffix(out, []byte("\n"))
+	}
+
+	cursor := editor.cursor
+	begin_edit(EDIT_OTHER)
+	editor.selecting = false
+	after := replace_between(start, end, out)
+	end_edit()
+	if whole {
+		set_cursor(cursor.y, cursor.x)
+	} else {
+		set_cursor(after.y, after.x)
+	}
+	if said != "" {
+		set_message("%s: %s", cmd, said)
+	}
+}
+
+func cmd_filter(args []string) {
+	if len(args) == 0 {
+		set_message("Usage: filter command")
+		return
+	}
+	filter(args[0])
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// a gap between matches costs a point a character, up to this many
+const MAX_GAP_COST = 5
+
+// Score how well a pattern matches some text as a subsequence, ignoring case
+// Matches at the start of words, runs of matching characters and matches in
+// the last part of a path score higher, gaps between matches and characters
+// skipped at the start of the file name score lower
+// Every way of lining the pattern up with the text is considered, so
+// "main" finds the file name in cmd/main.go rather than the m in cmd
+// ok is false if the pattern isn't in the text at all
+func fuzzy_score(pattern string, text string) (score int, ok bool) {
+	lower_pattern := strings.ToLower(pattern)
+	lower_text := strings.ToLower(text)
+	base := strings.LastIndexByte(text, '/') + 1
+	if len(pattern) == 0 {
+		return -len(text), true
+	}
+
+	// points for matching a character at i, leaving aside what came before it
+	points := func(i int) int {
+		p := 1
+		if i == 0 || strings.IndexByte("/_-. ", text[i-1]) >= 0 ||
+			unicode.IsLower(rune(text[i-1])) && unicode.IsUpper(rune(text[i])) {
+			p += 8
+		}
+		if i >= base {
+			p += 2
+		}
+		return p
+	}
+
+	// best[i] is the highest score with the pattern so far ending in a match at i
+	const none = math.MinInt32
+	best := make([]int, len(text))
+	next := make([]int, len(text))
+	for i := range best {
+		best[i] = none
+		if lower_text[i] == lower_pattern[0] {
+			best[i] = points(i)
+			if i > base {
+				best[i] -= min_int(i-base, MAX_GAP_COST)
+			}
+		}
+	}
+	for j := 1; j < len(lower_pattern); j++ {
+		// the best score ending far enough back that its gap costs the most there is
+		far := none
+		for i := range next {
+			next[i] = none
+			if k := i - 1 - MAX_GAP_COST; k >= 0 && best[k] > far {
+				far = best[k]
+			}
+			if lower_text[i] != lower_pattern[j] {
+				continue
+			}
+			score := none
+			if far != none {
+				score = far - MAX_GAP_COST
+			}
+			for k := i - 1; k >= 0 && k > i-1-MAX_GAP_COST; k-- {
+				if best[k] == none {
+					continue
+				}
+				s := best[k] - min_int(i-k-1, MAX_GAP_COST)
+				if k == i-1 {
+					s += 5
+				}
+				if s > score {
+					score = s
+				}
+			}
+			if score != none {
+				next[i] = score + points(i)
+			}
+		}
+		best, next = next, best
+	}
+
+	score = none
+	for _, s := range best {
+		if s > score {
+			score = s
+		}
+	}
+	if score == none {
+		return 0, false
+	}
+	// shorter text wins a tie
+	return score*16 - len(text), true
+}
+
+func min_int(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Indices of the items a pattern matches, best match first
+// An empty pattern matches everything in its original order
+func fuzzy_filter(pattern string, items []string) []int {
+	var found []int
+	if pattern == "" {
+		for i := range items {
+			found = append(found, i)
+		}
+		return found
+	}
+	scores := map[int]int{}
+	for i, item := range items {
+		if score, ok := fuzzy_score(pattern, item); ok {
+			found = append(found, i)
+			scores[i] = score
+		}
+	}
+	sort.SliceStable(found, func(a, b int) bool {
+		return scores[found[a]] > scores[found[b]]
+	})
+	return found
+}
+
+// Let the user choose from a list, typing in the message bar to narrow it down
+// The matches are shown over the text and picked from with the arrow keys
+// Returns the index of the chosen item, or -1 if nothing was chosen
+func pick(text string, items []string) int {
+	var shown []int
+	query := ""
+	show := func() {
+		editor.overlay = make([]string, len(shown))
+		for i, item := range shown {
+			editor.overlay[i] = items[item]
+		}
+	}
+	shown = fuzzy_filter(query, items)
+	editor.overlay_sel = 0
+	show()
+	defer func() { editor.overlay = nil }()
+
+	page := int(editor.dim.y) - 1
+	chosen := -1
+	prompt(text, &prompt_opts{
+		allow_empty: true,
+		on_key: func(in *input_line, key uint) bool {
+			sel := editor.overlay_sel
+			switch key {
+			case KEY_UP:
+				sel--
+			case KEY_DOWN:
+				sel++
+			case KEY_PG_UP:
+				sel -= page
+			case KEY_PG_DOWN:
+				sel += page
+			default:
+				return false
+			}
+			if sel >= len(shown) {
+				sel = len(shown) - 1
+			}
+			if sel < 0 {
+				sel = 0
+			}
+			editor.overlay_sel = sel
+			return true
+		},
+		callback: func(in *input_line, key uint) {
+			switch {
+			case key == '\r':
+				if editor.overlay_sel < len(shown) {
+					chosen = shown[editor.overlay_sel]
+				}
+			case string(in.text) != query:
+				query = string(in.text)
+				shown = fuzzy_filter(query, items)
+				editor.overlay_sel = 0
+				show()
+			}
+		},
+	})
+	return chosen
+}
+
+// Pick a file from anywhere under the current directory and open it
+func find_file() {
+	files := walk_project(".")
+	if len(files) == 0 {
+		set_message("No files found")
+		return
+	}
+	i := pick(fmt.Sprintf("Find file (%d): %%s", len(files)), files)
+	if i >= 0 {
+		open_buffer(files[i])
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFuzzyScore(t *testing.T) {
+	tests := []struct {
+		pattern, text string
+		ok            bool
+	}{
+		{"", "anything", true},
+		{"edt", "editor.go", true},
+		{"EDT", "editor.go", true},
+		{"og", "editor.go", true},
+		{"xyz", "editor.go", false},
+		{"editor.goo", "editor.go", false},
+	}
+	for _, test := range tests {
+		if _, ok := fuzzy_score(test.pattern, test.text); ok != test.ok {
+			t.Errorf("fuzzy_score(%q, %q) ok = %v, want %v", test.pattern, test.text, ok, test.ok)
+		}
+	}
+
+	better := [][3]string{
+		// pattern, should score higher, than
+		{"ed", "editor.go", "bad_ed.go"},
+		{"ed", "editor.go", "shared.go"},
+		{"fg", "foo_gen.go", "figure.go"},
+		{"main", "cmd/main.go", "main/other.go"},
+		{"main", "src/main.go", "mainly/other.go"},
+		{"x", "x.go", "x_longer.go"},
+	}
+	for _, test := range better {
+		high, _ := fuzzy_score(test[0], test[1])
+		low, _ := fuzzy_score(test[0], test[2])
+		if high <= low {
+			t.Errorf("fuzzy_score(%q): %q scored %d, not more than %q at %d", test[0], test[1], high, test[2], low)
+		}
+	}
+}
+
+// Score every way the pattern can line up with the text, the slow way
+func best_alignment(pattern string, text string) (int, bool) {
+	pattern, lower := strings.ToLower(pattern), strings.ToLower(text)
+	base := strings.LastIndexByte(text, '/') + 1
+	best, found := 0, false
+	var try func(j int, last int, score int)
+	try = func(j int, last int, score int) {
+		if j == len(pattern) {
+			if !found || score > best {
+				best, found = score, true
+			}
+			return
+		}
+		for i := last + 1; i < len(lower); i++ {
+			if lower[i] != pattern[j] {
+				continue
+			}
+			points := 1
+			if i == 0 || strings.IndexByte("/_-. ", text[i-1]) >= 0 {
+				points += 8
+			}
+			if i >= base {
+				points += 2
+			}
+			if last >= 0 {
+				if last == i-1 {
+					points += 5
+				}
+				points -= min_int(i-last-1, MAX_GAP_COST)
+			} else if i > base {
+				points -= min_int(i-base, MAX_GAP_COST)
+			}
+			try(j+1, i, score+points)
+		}
+	}
+	try(0, -1, 0)
+	return best*16 - len(text), found
+}
+
+func TestFuzzyScoreIsBest(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	for n := 0; n < 2000; n++ {
+		text := make([]byte, r.Intn(16))
+		for i := range text {
+			text[i] = "ab_/."[r.Intn(5)]
+		}
+		pattern := make([]byte, 1+r.Intn(3))
+		for i := range pattern {
+			pattern[i] = "ab"[r.Intn(2)]
+		}
+		got, ok := fuzzy_score(string(pattern), string(text))
+		want, want_ok := best_alignment(string(pattern), string(text))
+		if ok != want_ok || ok && got != want {
+			t.Fatalf("fuzzy_score(%q, %q) = %d, %v, want %d, %v", pattern, text, got, ok, want, want_ok)
+		}
+	}
+}
+
+func TestFuzzyFilter(t *testing.T) {
+	items := []string{"buffers.go", "editor.go", "syntax/langs.go", "events.go"}
+	if got := fuzzy_filter("", items); !reflect.DeepEqual(got, []int{0, 1, 2, 3}) {
+		t.Errorf("empty pattern gave %v, want everything in order", got)
+	}
+	if got := fuzzy_filter("ev", items); len(got) == 0 || got[0] != 3 {
+		t.Errorf("fuzzy_filter(ev) = %v, want events.go first", got)
+	}
+	if got := fuzzy_filter("qq", items); len(got) != 0 {
+		t.Errorf("fuzzy_filter(qq) = %v, want nothing", got)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"editor/syntax"
+	"errors"
+	"go/format"
+	"go/scanner"
+	"unicode"
+)
+
+// formatters set with the formatter command, by the language they are for,
+// such as "black -q -" for python or "clang-format" for c
+var formatters = map[string]string{}
+
+// Run some source through a formatter, either go/format or a shell command
+func run_formatter(formatter string, source []byte) ([]byte, error) {
+	if formatter == syntax.GO_FORMAT {
+		out, err := format.Source(source)
+		var list scanner.ErrorList
+		if errors.As(err, &list) && len(list) > 0 {
+			return nil, errors.New("syntax error on line " + list[0].Pos.String() + ": " + list[0].Msg)
+		}
+		return out, err
+	}
+	out, _, err := run_filter(formatter, source)
+	return out, err
+}
+
+// How many bytes before an offset aren't whitespace, which is what
+// formatting leaves alone, so the count finds the same place afterwards
+func count_significant(text []byte, offset int) int {
+	count := 0
+	for _, char := range text[:offset] {
+		if !unicode.IsSpace(rune(char)) {
+			count++
+		}
+	}
+	return count
+}
+
+// The offset just after a number of bytes that aren't whitespace
+func find_significant(text []byte, count int) int {
+	i := 0
+	for ; i < len(text) && count > 0; i++ {
+		if !unicode.IsSpace(rune(text[i])) {
+			count--
+		}
+	}
+	return i
+}
+
+// Position of a byte offset into the buffer's text
+func offset_to_pos(text []byte, offset int) vector {
+	y := bytes.Count(text[:offset], []byte("\n"))
+	x := offset - (bytes.LastIndexByte(text[:offset], '\n') + 1)
+	return vector{uint(x), uint(y)}
+}
+
+// Format the buffer with its language's formatter, keeping the cursor on the
+// same bit of code, as a single undo step
+// Returns false, leaving the buffer alone, if the code couldn't be formatted
+func format_buffer() bool {
+	formatter := editor.language.Formatter
+	if formatter == "" || editor.used_rows == 0 {
+		return true
+	}
+
+	b := buf{}
+	stringify(&b)
+	out, err := run_formatter(formatter, b.buffer)
+	if err != nil {
+		set_message("Not saved, %s", err)
+		return false
+	}
+	if bytes.Equal(out, b.buffer) {
+		return true
+	}
+
+	offset := editor.cursor.x
+	for y := uint(0); y < editor.cursor.y; y++ {
+		offset += editor.lines[y].len + 1
+	}
+	count := count_significant(b.buffer, int(offset))
+	// a cursor after the end of the code on a line stays at the end of the line
+	rest := editor.lines[editor.cursor.y].text[editor.cursor.x:]
+	at_end := len(bytes.TrimSpace(rest)) == 0
+
+	begin_edit(EDIT_OTHER)
+	last := editor.used_rows - 1
+	replace_between(vector{0, 0}, vector{editor.lines[last].len, last}, bytes.TrimSuffix(out, []byte("\n")))
+	end_edit()
+
+	i := find_significant(out, count)
+	if at_end && count > 0 {
+		for i < len(out) && out[i] != '\n' {
+			i++
+		}
+	} else {
+		for i < len(out) && out[i] != '\n' && unicode.IsSpace(rune(out[i])) {
+			i++
+		}
+	}
+	pos := offset_to_pos(out, i)
+	set_cursor(pos.y, pos.x)
+	return true
+}
+
+func set_format_on_save(on bool) {
+	editor.format_on_save = on
+	if on {
+		set_message("Formatting on save")
+	} else {
+		set_message("Not formatting on save")
+	}
+}
+
+func cmd_format(args []string) {
+	if editor.language.Formatter == "" {
+		set_message("No formatter for this file")
+		return
+	}
+	if writable() && format_buffer() {
+		set_message("Formatted")
+	}
+}
+
+// Show or set the formatter for the current buffer's language, in every
+// buffer of that language, off takes it away
+func cmd_formatter(args []string) {
+	id := editor.language.Language_id
+	if id == "" {
+		set_message("No formatter can be set for this file")
+		return
+	}
+	if len(args) == 0 {
+		if editor.language.Formatter == "" {
+			set_message("No formatter for %s", id)
+		} else {
+			set_message("Formatter for %s is %s", id, editor.language.Formatter)
+		}
+		return
+	}
+	formatter := args[0]
+	if formatter == "off" {
+		formatter = ""
+	}
+	formatters[id] = formatter
+	for i := range editor.buffers {
+		if editor.buffers[i].language.Language_id == id {
+			editor.buffers[i].language.Formatter = formatter
+		}
+	}
+	editor.language.Formatter = formatter
+	if formatter == "" {
+		set_message("No formatter for %s", id)
+	} else {
+		set_message("Formatter for %s set to %s", id, formatter)
+	}
+}
+
+func cmd_format_on_save(args []string) {
+	if on, ok := parse_switch(args, editor.format_on_save); ok {
+		set_format_on_save(on)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Run git in a directory, giving it input if there is any, and
+// returning what it wrote to stdout
+// Failures carry the last thing git said about them
+func run_git(dir string, input []byte, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Stdin = bytes.NewReader(input)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if said := last_said(stderr.String()); said != "" {
+			return nil, errors.New(said)
+		}
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
+
+// The directory to run git in for a file, and the file's name in it
+func split_path(file_name string) (string, string) {
+	dir, name := filepath.Split(file_name)
+	if dir == "" {
+		dir = "."
+	}
+	return dir, name
+}
+
+// Split file contents into lines the way load_file does
+func split_lines(data []byte) []string {
+	if len(data) == 0 {
+		return nil
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, "\r")
+	}
+	return lines
+}
+
+// Read the current buffer's file as it was in the last commit, so its
+// changes can be marked, files that aren't in git get no markers
+func load_git_head() {
+	editor.git_head = nil
+	editor.git_hunks = nil
+	editor.git_version = -1
+	if editor.file_name == "" || editor.read_only {
+		return
+	}
+	if _, err := exec.LookPath("git"); err != nil {
+		return
+	}
+	dir, name := split_path(editor.file_name)
+	data, err := run_git(dir, nil, "show", "HEAD:./"+name)
+	if err != nil {
+		return
+	}
+	editor.git_head = split_lines(data)
+	// an empty file in HEAD still counts as being in git
+	if editor.git_head == nil {
+		editor.git_head = []string{}
+	}
+	update_git_hunks()
+}
+
+// Work out the changes since the last commit again if the buffer has been edited
+// This is done once per key rather than when drawing, since the screen
+// is redrawn far more often than the buffer changes
+func update_git_hunks() {
+	if editor.git_head == nil || editor.git_version == editor.version {
+		return
+	}
+	lines := make([]string, editor.used_rows)
+	for i := range lines {
+		lines[i] = string(editor.lines[i].text)
+	}
+	editor.git_hunks = diff_lines(editor.git_head, lines)
+	editor.git_version = editor.version
+}
+
+// The row a hunk is marked on, lines that were deleted are marked
+// on the line after them, or the last line if they were at the end
+func hunk_row(h diff_hunk) uint {
+	row := uint(h.new_start)
+	if h.new_count == 0 && row >= editor.used_rows && row > 0 {
+		row--
+	}
+	return row
+}
+
+func git_sign(row uint) (byte, byte) {
+	for _, h := range editor.git_hunks {
+		switch {
+		case h.new_count == 0 && hunk_row(h) == row:
+			return '_', T_RED
+		case row < uint(h.new_start) || row >= uint(h.new_start+h.new_count):
+		case h.old_count == 0:
+			return '+', T_GREEN
+		default:
+			return '~', T_YELLOW
+		}
+	}
+	return 0, 0
+}
+
+// Move to the next or previous changed hunk, going round at the ends
+func next_hunk(step int) {
+	update_git_hunks()
+	hunks := editor.git_hunks
+	if editor.git_head == nil {
+		set_message("%s isn't in git", buffer_name(&editor.buffer_t))
+		return
+	}
+	if len(hunks) == 0 {
+		set_message("No changes")
+		return
+	}
+	next_row(len(hunks), func(i int) uint { return hunk_row(hunks[i]) }, step, "change")
+}
+
+// The hunk the cursor is in, or nil if it isn't in one
+func cursor_hunk() *diff_hunk {
+	for i := range editor.git_hunks {
+		h := &editor.git_hunks[i]
+		y := int(editor.cursor.y)
+		if y >= h.new_start && y < h.new_start+h.new_count || h.new_count == 0 && hunk_row(*h) == editor.cursor.y {
+			return h
+		}
+	}
+	return nil
+}
+
+// Put the hunk under the cursor back the way it was in the last commit
+func revert_hunk() {
+	if !writable() {
+		return
+	}
+	update_git_hunks()
+	h := cursor_hunk()
+	if h == nil {
+		set_message("No change here")
+		return
+	}
+	begin_edit(EDIT_OTHER)
+	replace_rows(uint(h.new_start), uint(h.new_start+h.new_count), editor.git_head[h.old_start:h.old_start+h.old_count])
+	end_edit()
+	set_cursor(uint(h.new_start), 0)
+	update_git_hunks()
+	set_message("Reverted the change")
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A place in a file, with the line and column counted from 0
+type file_loc struct {
+	file string
+	y    uint
+	x    uint
+}
+
+// Open a file and put the cursor at a place in it
+func jump_to(loc file_loc) bool {
+	if !open_buffer(loc.file) {
+		return false
+	}
+	set_cursor(loc.y, loc.x)
+	return true
+}
+
+// places jumped away from, most recent last, so jumps can be undone
+var jump_stack []file_loc
+
+const JUMP_STACK_LIMIT = 100
+
+// Where the cursor is, as somewhere a jump can come back to
+// List buffers and buffers without a file give an empty location
+func here() file_loc {
+	if editor.file_name == "" || editor.read_only {
+		return file_loc{}
+	}
+	return file_loc{editor.file_name, editor.cursor.y, editor.cursor.x}
+}
+
+// Remember where the cursor is before jumping somewhere else
+func push_jump() {
+	remember_jump(here())
+}
+
+func remember_jump(from file_loc) {
+	if from.file == "" {
+		return
+	}
+	jump_stack = append(jump_stack, from)
+	if len(jump_stack) > JUMP_STACK_LIMIT {
+		jump_stack = jump_stack[1:]
+	}
+}
+
+// Open a file to jump into it, only remembering where the
+// jump was from once the file has opened
+func open_jump(file_name string) bool {
+	from := here()
+	if !open_buffer(file_name) {
+		return false
+	}
+	remember_jump(from)
+	return true
+}
+
+// Go back to where the cursor was before the last jump
+func jump_back() {
+	if len(jump_stack) == 0 {
+		set_message("Nowhere to go back to")
+		return
+	}
+	loc := jump_stack[len(jump_stack)-1]
+	jump_stack = jump_stack[:len(jump_stack)-1]
+	jump_to(loc)
+}
+
+// Jump to where the word under the cursor is defined
+// The language server is asked if there is one, otherwise Go is parsed
+// directly and anything else is looked up in the tags file
+func go_to_definition() {
+	if client := language_server(); client != nil {
+		lsp_definition(client)
+		return
+	}
+	if is_go_file() {
+		go_definition()
+		return
+	}
+	name := cursor_word()
+	if name == "" {
+		set_message("No word under the cursor")
+		return
+	}
+	jump_to_tag_name(name)
+}
+
+// Move the cursor to a line and column, both counted from 0
+// Out of range values are clamped, and the view is centred on the
+// cursor if it lands off screen
+func set_cursor(y uint, x uint) {
+	end_edit()
+	if editor.used_rows == 0 {
+		editor.cursor = vector{0, 0}
+		return
+	}
+	if y >= editor.used_rows {
+		y = editor.used_rows - 1
+	}
+	if x > editor.lines[y].len {
+		x = editor.lines[y].len
+	}
+	editor.cursor = vector{x, y}
+
+	if y < editor.offset.y || y >= editor.offset.y+editor.dim.y {
+		editor.offset_row = 0
+		if y > editor.dim.y/2 {
+			editor.offset.y = y - editor.dim.y/2
+		} else {
+			editor.offset.y = 0
+		}
+	}
+}
+
+// Move to the start of the next of some rows in order after the cursor,
+// or the one before it when step is negative, wrapping around at the ends
+// what is the name of the things on them for saying it wrapped
+func next_row(rows int, row func(i int) uint, step int, what string) {
+	i := 0
+	if step > 0 {
+		for i < rows && row(i) <= editor.cursor.y {
+			i++
+		}
+		if i == rows {
+			i = 0
+			set_message("Wrapped to the first %s", what)
+		}
+	} else {
+		i = rows - 1
+		for i >= 0 && row(i) >= editor.cursor.y {
+			i--
+		}
+		if i < 0 {
+			i = rows - 1
+			set_message("Wrapped to the last %s", what)
+		}
+	}
+	set_cursor(row(i), 0)
+}
+
+// Work out where a location typed into the goto prompt points to
+// Accepts N, N:C, +N and -N relative to the cursor, and N% of the way through the file
+// Lines and columns are counted from 1, the result is counted from 0
+func parse_location(spec string) (vector, bool) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return vector{}, false
+	}
+
+	if strings.HasSuffix(spec, "%") || strings.HasPrefix(spec, "%") {
+		percent, err := strconv.Atoi(strings.Trim(spec, "%"))
+		if err != nil || percent < 0 {
+			return vector{}, false
+		}
+		if percent > 100 {
+			percent = 100
+		}
+		y := uint(percent) * editor.used_rows / 100
+		if y > 0 && y >= editor.used_rows {
+			y = editor.used_rows - 1
+		}
+		return vector{0, y}, true
+	}
+
+	if spec[0] == '+' || spec[0] == '-' {
+		n, err := strconv.Atoi(spec[1:])
+		if err != nil || n < 0 {
+			return vector{}, false
+		}
+		y := int(editor.cursor.y)
+		if spec[0] == '+' {
+			y += n
+		} else {
+			y -= n
+		}
+		if y < 0 {
+			y = 0
+		}
+		return vector{0, uint(y)}, true
+	}
+
+	fields := strings.SplitN(spec, ":", 2)
+	line, err := strconv.Atoi(fields[0])
+	if err != nil || line < 1 {
+		return vector{}, false
+	}
+	col := 1
+	if len(fields) == 2 && fields[1] != "" {
+		col, err = strconv.Atoi(fields[1])
+		if err != nil || col < 1 {
+			return vector{}, false
+		}
+	}
+	return vector{uint(col - 1), uint(line - 1)}, true
+}
+
+// Jump to a location given in any of the forms parse_location understands
+func goto_location(spec string) bool {
+	pos, ok := parse_location(spec)
+	if !ok {
+		return false
+	}
+	set_cursor(pos.y, pos.x)
+	return true
+}
+
+func goto_prompt() {
+	spec := prompt("Go to line[:col], +/-N or N%%: %s", &prompt_opts{history: "goto"})
+	if spec == "" {
+		return
+	}
+	if !goto_location(spec) {
+		set_message("Invalid location: %q", spec)
+	}
+}
+
+var location_suffix = regexp.MustCompile(`:(\d+)(:\d+)?:?$`)
+
+// Split a command line argument like main.go:120:5 into the file and the location
+// A file that really has such a name is left alone
+func split_location(arg string) (string, string) {
+	if _, err := os.Stat(arg); err == nil {
+		return arg, ""
+	}
+	loc := location_suffix.FindStringIndex(arg)
+	if loc == nil || loc[0] == 0 {
+		return arg, ""
+	}
+	return arg[:loc[0]], strings.TrimSuffix(arg[loc[0]+1:], ":")
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseLocation(t *testing.T) {
+	editor.used_rows = 200
+	editor.cursor = vector{3, 50}
+	defer func() {
+		editor.used_rows = 0
+		editor.cursor = vector{}
+	}()
+
+	tests := []struct {
+		spec string
+		want vector
+		ok   bool
+	}{
+		{"12", vector{0, 11}, true},
+		{" 12 ", vector{0, 11}, true},
+		{"12:5", vector{4, 11}, true},
+		{"12:", vector{0, 11}, true},
+		{"+10", vector{0, 60}, true},
+		{"-10", vector{0, 40}, true},
+		{"-100", vector{0, 0}, true},
+		{"50%", vector{0, 100}, true},
+		{"%25", vector{0, 50}, true},
+		{"150%", vector{0, 199}, true},
+		{"", vector{}, false},
+		{"0", vector{}, false},
+		{"12:0", vector{}, false},
+		{"abc", vector{}, false},
+		{"+x", vector{}, false},
+		{"-5%", vector{}, false},
+	}
+	for _, test := range tests {
+		got, ok := parse_location(test.spec)
+		if ok != test.ok || got != test.want {
+			t.Errorf("parse_location(%q) = %v, %v, want %v, %v", test.spec, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestSplitLocation(t *testing.T) {
+	tests := []struct {
+		arg, file, loc string
+	}{
+		{"main.go", "main.go", ""},
+		{"main.go:120", "main.go", "120"},
+		{"main.go:120:5", "main.go", "120:5"},
+		{"main.go:120:5:", "main.go", "120:5"},
+		{":12", ":12", ""},
+	}
+	for _, test := range tests {
+		file, loc := split_location(test.arg)
+		if file != test.file || loc != test.loc {
+			t.Errorf("split_location(%q) = %q, %q, want %q, %q", test.arg, file, loc, test.file, test.loc)
+		}
+	}
+}
+
+func TestNextRow(t *testing.T) {
+	saved := editor.buffer_t
+	defer func() { editor.buffer_t = saved }()
+	set_buffer_text(strings.Repeat("line\n", 20))
+
+	rows := []uint{3, 8, 15}
+	row := func(i int) uint { return rows[i] }
+	tests := []struct {
+		from    uint
+		step    int
+		want    uint
+		wrapped string
+	}{
+		{0, 1, 3, ""},
+		{3, 1, 8, ""},
+		{10, 1, 15, ""},
+		{15, 1, 3, "Wrapped to the first change"},
+		{10, -1, 8, ""},
+		{8, -1, 3, ""},
+		{3, -1, 15, "Wrapped to the last change"},
+	}
+	for _, test := range tests {
+		editor.cursor = vector{2, test.from}
+		editor.msg = ""
+		next_row(len(rows), row, test.step, "change")
+		if editor.cursor != (vector{0, test.want}) || editor.msg != test.wrapped {
+			t.Errorf("from %d by %d went to %v saying %q, want row %d saying %q",
+				test.from, test.step, editor.cursor, editor.msg, test.want, test.wrapped)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// longest bit of a matching line shown in the results
+const GREP_LINE_MAX = 200
+
+// how far into a file to look for a null byte before calling it binary
+const BINARY_CHECK_LEN = 8000
+
+// closed to stop the search that is running, if any
+var grep_cancel chan struct{}
+
+// Find the lines of a file that match, as locations and lines for the results list
+func grep_file(re *regexp.Regexp, path string) ([]file_loc, []string) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil
+	}
+	head := data
+	if len(head) > BINARY_CHECK_LEN {
+		head = head[:BINARY_CHECK_LEN]
+	}
+	if bytes.IndexByte(head, 0) >= 0 {
+		return nil, nil
+	}
+
+	var found []file_loc
+	var lines []string
+	for y, text := range bytes.Split(data, []byte("\n")) {
+		text = bytes.TrimSuffix(text, []byte("\r"))
+		match := re.FindIndex(text)
+		if match == nil {
+			continue
+		}
+		found = append(found, file_loc{path, uint(y), uint(match[0])})
+		if len(text) > GREP_LINE_MAX {
+			text = text[:GREP_LINE_MAX]
+		}
+		lines = append(lines, fmt.Sprintf("%s:%d: %s", path, y+1, text))
+	}
+	return found, lines
+}
+
+// Search the files under a directory for a pattern, listing the matches as
+// they are found, enter on a match opens the file there
+// Starting a new search or closing the list stops the old one
+func grep(pattern string, dir string, literal bool, ignore_case bool) {
+	expr := pattern
+	if literal {
+		expr = regexp.QuoteMeta(pattern)
+	}
+	if ignore_case {
+		expr = "(?i)" + expr
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		set_message("Invalid pattern: %s", err)
+		return
+	}
+
+	if grep_cancel != nil {
+		close(grep_cancel)
+	}
+	cancel := make(chan struct{})
+	grep_cancel = cancel
+
+	// only ever touched on the main goroutine, by events
+	var results []file_loc
+	files := 0
+
+	show_list("grep", fmt.Sprintf("[grep %s]", pattern), nil, func(row uint) {
+		if row < uint(len(results)) {
+			jump_to(results[row])
+		}
+	})
+	set_message("Searching for %q...", pattern)
+
+	go func() {
+		for _, file := range walk_project(dir) {
+			select {
+			case <-cancel:
+				return
+			default:
+			}
+			found, lines := grep_file(re, filepath.Join(dir, file))
+			if len(found) == 0 {
+				continue
+			}
+			post_event(func() {
+				if grep_cancel != cancel {
+					return
+				}
+				if !append_to_list("grep", lines) {
+					close(cancel)
+					grep_cancel = nil
+					return
+				}
+				results = append(results, found...)
+				files++
+			})
+		}
+		post_event(func() {
+			if grep_cancel != cancel {
+				return
+			}
+			grep_cancel = nil
+			set_message("%d matches in %d files", len(results), files)
+		})
+	}()
+}
+
+func cmd_grep(args []string) {
+	literal, ignore_case := false, false
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		flag := args[0]
+		args = args[1:]
+		if flag == "--" {
+			break
+		}
+		switch flag {
+		case "-F":
+			literal = true
+		case "-i":
+			ignore_case = true
+		default:
+			set_message("Unknown flag: %s", flag)
+			return
+		}
+	}
+	if len(args) == 0 || len(args) > 2 {
+		set_message("Usage: grep [-F] [-i] pattern [dir]")
+		return
+	}
+	dir := "."
+	if len(args) == 2 {
+		dir = args[1]
+	}
+	grep(args[0], dir, literal, ignore_case)
+}
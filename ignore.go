@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// most files the project walk will collect, so huge trees don't hang the editor
+const WALK_LIMIT = 100000
+
+// A pattern from a .gitignore file
+type ignore_rule struct {
+	re       *regexp.Regexp
+	negate   bool
+	dir_only bool
+}
+
+// The rules from one .gitignore, which apply to paths under dir
+type ignore_list struct {
+	dir   string
+	rules []ignore_rule
+}
+
+// Turn a gitignore glob into a regular expression over slash separated paths
+// Patterns without a slash in them match at any depth
+func compile_ignore(pattern string) (*regexp.Regexp, error) {
+	anchored := strings.Contains(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+
+	var re strings.Builder
+	re.WriteString("^")
+	if !anchored {
+		re.WriteString("(.*/)?")
+	}
+	for i := 0; i < len(pattern); i++ {
+		char := pattern[i]
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			re.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			re.WriteString(".*")
+			i++
+		case char == '*':
+			re.WriteString("[^/]*")
+		case char == '?':
+			re.WriteString("[^/]")
+		case char == '[':
+			end := strings.IndexByte(pattern[i:], ']')
+			if end < 0 {
+				re.WriteString(`\[`)
+				continue
+			}
+			class := pattern[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			re.WriteString("[" + class + "]")
+			i += end
+		case char == '\\' && i+1 < len(pattern):
+			i++
+			re.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		default:
+			re.WriteString(regexp.QuoteMeta(string(char)))
+		}
+	}
+	// a directory pattern also covers everything inside it
+	re.WriteString("(/.*)?$")
+	return regexp.Compile(re.String())
+}
+
+// Read the rules from an ignore file, a missing file has no rules
+func read_ignore_file(path string, dir string) *ignore_list {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	list := &ignore_list{dir: dir}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var rule ignore_rule
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dir_only = true
+			line = strings.TrimRight(line, "/")
+		}
+		re, err := compile_ignore(line)
+		if err != nil {
+			continue
+		}
+		rule.re = re
+		list.rules = append(list.rules, rule)
+	}
+	return list
+}
+
+// Whether a path is ignored, the last rule that matches it wins
+func is_ignored(lists []*ignore_list, path string, is_dir bool) bool {
+	ignored := false
+	for _, list := range lists {
+		rel := path
+		if list.dir != "" {
+			if !strings.HasPrefix(path, list.dir+"/") {
+				continue
+			}
+			rel = path[len(list.dir)+1:]
+		}
+		for _, rule := range list.rules {
+			// files in an ignored directory are never reached, since it isn't walked
+			if rule.dir_only && !is_dir {
+				continue
+			}
+			if rule.re.MatchString(rel) {
+				ignored = !rule.negate
+			}
+		}
+	}
+	return ignored
+}
+
+// List the files under a directory, skipping anything .gitignore says to
+// Paths are relative to root and use forward slashes
+func walk_project(root string) []string {
+	var files []string
+	var lists []*ignore_list
+	if list := read_ignore_file(filepath.Join(root, ".git", "info", "exclude"), ""); list != nil {
+		lists = append(lists, list)
+	}
+
+	var walk func(dir string, lists []*ignore_list)
+	walk = func(dir string, lists []*ignore_list) {
+		if len(files) >= WALK_LIMIT {
+			return
+		}
+		if list := read_ignore_file(filepath.Join(root, dir, ".gitignore"), dir); list != nil {
+			lists = append(lists[:len(lists):len(lists)], list)
+		}
+		entries, err := os.ReadDir(filepath.Join(root, dir))
+		if err != nil {
+			return
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if name == ".git" {
+				continue
+			}
+			path := name
+			if dir != "" {
+				path = dir + "/" + name
+			}
+			if is_ignored(lists, path, entry.IsDir()) {
+				continue
+			}
+			if entry.IsDir() {
+				walk(path, lists)
+			} else if entry.Type().IsRegular() {
+				files = append(files, path)
+				if len(files) >= WALK_LIMIT {
+					return
+				}
+			}
+		}
+	}
+	walk("", lists)
+	return files
+}
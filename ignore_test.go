@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestCompileIgnore(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		match   bool
+	}{
+		{"*.o", "main.o", true},
+		{"*.o", "src/lib/main.o", true},
+		{"*.o", "main.go", false},
+		{"build", "build", true},
+		{"build", "sub/build", true},
+		{"build", "build/out/x", true},
+		{"build", "builder", false},
+		{"/build", "build", true},
+		{"/build", "sub/build", false},
+		{"doc/*.txt", "doc/a.txt", true},
+		{"doc/*.txt", "doc/sub/a.txt", false},
+		{"doc/**/*.txt", "doc/sub/deep/a.txt", true},
+		{"doc/**/*.txt", "doc/a.txt", true},
+		{"**/tmp", "a/b/tmp", true},
+		{"file?.go", "file1.go", true},
+		{"file?.go", "file10.go", false},
+		{"[ab].go", "a.go", true},
+		{"[!ab].go", "a.go", false},
+		{"[!ab].go", "c.go", true},
+		{`\#notes`, "#notes", true},
+		{"a.b", "axb", false},
+	}
+	for _, test := range tests {
+		re, err := compile_ignore(test.pattern)
+		if err != nil {
+			t.Errorf("compile_ignore(%q): %v", test.pattern, err)
+			continue
+		}
+		if got := re.MatchString(test.path); got != test.match {
+			t.Errorf("%q matching %q = %v, want %v", test.pattern, test.path, got, test.match)
+		}
+	}
+}
+
+func TestWalkProject(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		".gitignore":        "*.log\nbuild/\n!keep.log\n",
+		"main.go":           "",
+		"debug.log":         "",
+		"keep.log":          "",
+		"build/out":         "",
+		"src/.gitignore":    "gen.go\n",
+		"src/gen.go":        "",
+		"src/lib.go":        "",
+		"other/gen.go":      "",
+		".git/HEAD":         "",
+		".git/info/exclude": "secret\n",
+		"secret":            "",
+	}
+	for name, text := range files {
+		path := filepath.Join(root, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := walk_project(root)
+	sort.Strings(got)
+	want := []string{".gitignore", "keep.log", "main.go", "other/gen.go", "src/.gitignore", "src/lib.go"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("walk_project = %v, want %v", got, want)
+	}
+}
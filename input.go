@@ -0,0 +1,288 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// A single line of text being edited in the message bar
+type input_line struct {
+	text   []byte
+	cursor uint
+}
+
+// Optional behaviour for a prompt
+type prompt_opts struct {
+	// name of the history list to use, empty for no history
+	history string
+	// given the text before the cursor, return where the text being completed
+	// starts and what it could be replaced with
+	complete func(text string) (int, []string)
+	// gets each key before the line editor does, returning true if it dealt with it
+	on_key func(in *input_line, key uint) bool
+	// called after every key
+	callback func(in *input_line, key uint)
+	// whether enter accepts an empty line rather than being ignored
+	allow_empty bool
+}
+
+// earlier entries for each kind of prompt, oldest first
+var histories = map[string][]string{}
+
+func (in *input_line) set(text string) {
+	in.text = []byte(text)
+	in.cursor = uint(len(in.text))
+}
+
+func (in *input_line) insert(chars []byte) {
+	text := append([]byte{}, in.text[:in.cursor]...)
+	text = append(text, chars...)
+	in.text = append(text, in.text[in.cursor:]...)
+	in.cursor += uint(len(chars))
+}
+
+func (in *input_line) delete(from uint, to uint) {
+	in.text = append(in.text[:from:from], in.text[to:]...)
+	in.cursor = from
+}
+
+// The input as a line, so word movement works the same as in the editor
+func (in *input_line) as_line() *line_t {
+	return &line_t{text: in.text, len: uint(len(in.text))}
+}
+
+// Apply one of the line editing keys to the input
+func (in *input_line) edit(key uint) {
+	length := uint(len(in.text))
+	switch key {
+	case KEY_LEFT:
+		if in.cursor > 0 {
+			in.cursor--
+		}
+	case KEY_RIGHT:
+		if in.cursor < length {
+			in.cursor++
+		}
+	case KEY_CTRL_LEFT:
+		in.cursor = word_start(in.as_line(), in.cursor)
+	case KEY_CTRL_RIGHT:
+		in.cursor = word_end(in.as_line(), in.cursor)
+	case KEY_HOME, 0x01: // ctrl-a
+		in.cursor = 0
+	case KEY_END, 0x05: // ctrl-e
+		in.cursor = length
+	case KEY_BACKSPACE:
+		if in.cursor > 0 {
+			in.delete(in.cursor-1, in.cursor)
+		}
+	case KEY_DEL:
+		if in.cursor < length {
+			in.delete(in.cursor, in.cursor+1)
+		}
+	case KEY_DEL_WORD, 0x17: // ctrl-w
+		in.delete(word_start(in.as_line(), in.cursor), in.cursor)
+	case KEY_CTRL_DEL:
+		in.delete(in.cursor, word_end(in.as_line(), in.cursor))
+	case 0x15: // ctrl-u
+		in.delete(0, in.cursor)
+	default:
+		// special keys are out of the range of a byte
+		if key < 0x80 && unicode.IsPrint(rune(key)) || key >= 0x80 && key <= 0xFF {
+			in.insert([]byte{byte(key)})
+		}
+	}
+}
+
+// Ask for a line of input in the message bar
+// text is a format string with a %s where the input goes
+// Returns the empty string if the prompt was cancelled with escape
+func prompt(text string, opts *prompt_opts) string {
+	if opts == nil {
+		opts = &prompt_opts{}
+	}
+	before, after := text, ""
+	if i := strings.Index(text, "%s"); i >= 0 {
+		before, after = text[:i], text[i+2:]
+	}
+
+	var in input_line
+	history := histories[opts.history]
+	history_at := len(history)
+	draft := ""
+
+	// completions being cycled through, and where they go in the input
+	var matches []string
+	match_at, match_start := 0, 0
+
+	// a prompt can be opened from inside another, which is still open after it
+	was_prompting := editor.prompting
+	editor.prompting = true
+	defer func() { editor.prompting = was_prompting }()
+
+	for {
+		msg := set_prompt_message(before, after, &in)
+		if len(matches) > 1 {
+			set_message("%s  {%s}", msg, strings.Join(matches, " "))
+		}
+		refresh_terminal()
+
+		key := read_input()
+		if opts.on_key != nil && opts.on_key(&in, key) {
+			if opts.callback != nil {
+				opts.callback(&in, key)
+			}
+			continue
+		}
+
+		switch key {
+		case '\r':
+			if len(in.text) == 0 && !opts.allow_empty {
+				break
+			}
+			set_message("")
+			line := string(in.text)
+			if opts.history != "" {
+				history = histories[opts.history]
+				if line != "" && (len(history) == 0 || history[len(history)-1] != line) {
+					histories[opts.history] = append(history, line)
+				}
+			}
+			if opts.callback != nil {
+				opts.callback(&in, key)
+			}
+			return line
+		case '\x1b':
+			set_message("")
+			if opts.callback != nil {
+				opts.callback(&in, key)
+			}
+			return ""
+		case KEY_TAB:
+			if opts.complete == nil {
+				break
+			}
+			// complete as far as all the matches agree, then cycle through them
+			if matches == nil {
+				start, found := opts.complete(string(in.text[:in.cursor]))
+				if len(found) == 0 {
+					break
+				}
+				in.delete(uint(start), in.cursor)
+				in.insert([]byte(common_prefix(found)))
+				if len(found) > 1 {
+					matches, match_at, match_start = found, -1, start
+				}
+				break
+			}
+			match_at = (match_at + 1) % len(matches)
+			in.delete(uint(match_start), in.cursor)
+			in.insert([]byte(matches[match_at]))
+		case KEY_UP:
+			if history_at == len(history) {
+				draft = string(in.text)
+			}
+			if history_at > 0 {
+				history_at--
+				in.set(history[history_at])
+			}
+		case KEY_DOWN:
+			if history_at < len(history) {
+				history_at++
+				if history_at == len(history) {
+					in.set(draft)
+				} else {
+					in.set(history[history_at])
+				}
+			}
+		default:
+			in.edit(key)
+		}
+		if key != KEY_TAB {
+			matches = nil
+		}
+		if opts.callback != nil {
+			opts.callback(&in, key)
+		}
+	}
+}
+
+// Show the prompt and work out where the cursor goes in the message bar
+func set_prompt_message(before string, after string, in *input_line) string {
+	set_message(before+"%s"+after, in.text)
+	head := len(editor.msg) - len(in.text) - len(after)
+	editor.prompt_x = uint(head) + in.cursor
+	return editor.msg
+}
+
+// Longest prefix that all the strings share
+func common_prefix(strs []string) string {
+	if len(strs) == 0 {
+		return ""
+	}
+	prefix := strs[0]
+	for _, str := range strs[1:] {
+		for !strings.HasPrefix(str, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
+
+// Complete the last space separated word of some text using another completer
+func complete_last_word(text string, complete func(string) []string) (int, []string) {
+	start := strings.LastIndexAny(text, " \t") + 1
+	return start, complete(text[start:])
+}
+
+// A path with a leading ~ standing for the home directory, as the shell
+// would have it, so paths typed into prompts work the same as on the command line
+func expand_home(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[1:])
+}
+
+// Files and directories starting with a path, directories end in a slash
+func complete_path(path string) []string {
+	dir, base := filepath.Split(path)
+	read_dir := dir
+	if read_dir == "" {
+		read_dir = "."
+	}
+	read_dir = expand_home(read_dir)
+
+	entries, err := os.ReadDir(read_dir)
+	if err != nil {
+		return nil
+	}
+	var found []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, base) {
+			continue
+		}
+		// hidden files only come up when asked for
+		if strings.HasPrefix(name, ".") && !strings.HasPrefix(base, ".") {
+			continue
+		}
+		if entry.IsDir() {
+			name += "/"
+		}
+		found = append(found, dir+name)
+	}
+	sort.Strings(found)
+	return found
+}
+
+// Completer for prompts that take a single file name
+func complete_file_name(text string) (int, []string) {
+	return 0, complete_path(text)
+}
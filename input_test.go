@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestExpandHome(t *testing.T) {
+	t.Setenv("HOME", "/home/ada")
+	tests := []struct {
+		path, want string
+	}{
+		{"~", "/home/ada"},
+		{"~/", "/home/ada"},
+		{"~/src/main.go", "/home/ada/src/main.go"},
+		{"~ada/main.go", "~ada/main.go"},
+		{"src/~/main.go", "src/~/main.go"},
+		{"/tmp/x", "/tmp/x"},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if got := expand_home(test.path); got != test.want {
+			t.Errorf("expand_home(%q) = %q, want %q", test.path, got, test.want)
+		}
+	}
+}
+
+func TestCompletePath(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	os.Mkdir(filepath.Join(home, "src"), 0755)
+	os.WriteFile(filepath.Join(home, "foo.go"), nil, 0644)
+	os.WriteFile(filepath.Join(home, "food.txt"), nil, 0644)
+	os.WriteFile(filepath.Join(home, ".hidden"), nil, 0644)
+
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{home + "/fo", []string{home + "/foo.go", home + "/food.txt"}},
+		{home + "/", []string{home + "/foo.go", home + "/food.txt", home + "/src/"}},
+		{home + "/.h", []string{home + "/.hidden"}},
+		{"~/s", []string{"~/src/"}},
+		{home + "/nothing", nil},
+	}
+	for _, test := range tests {
+		if got := complete_path(test.path); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("complete_path(%q) = %q, want %q", test.path, got, test.want)
+		}
+	}
+
+	// what ~ completes to opens the file it names, not one called ~
+	saved := editor
+	defer func() { editor = saved }()
+	fresh_editor()
+	if !open_buffer("~/food.txt") || editor.file_name != filepath.Join(home, "food.txt") || editor.new_file {
+		t.Errorf("opening ~/food.txt opened %q, new file %v", editor.file_name, editor.new_file)
+	}
+}
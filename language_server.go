@@ -0,0 +1,386 @@
+package main
+
+import (
+	"editor/lsp"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// running language servers by language id, and the ones that have been
+// started already, whether or not that worked
+var servers = map[string]*lsp.Client{}
+var servers_started = map[string]bool{}
+
+// the row whose diagnostic was last shown, so it is only shown on arriving at the row
+var diagnostic_row = -1
+
+// The text of a buffer as it would be saved
+func buffer_text(b *buffer_t) string {
+	var text strings.Builder
+	for _, line := range b.lines {
+		text.Write(line.text)
+		text.WriteByte('\n')
+	}
+	return text.String()
+}
+
+// The server for the current buffer's language, starting it if it hasn't been
+// Returns nil while the server is starting, or if there isn't one
+func language_server() *lsp.Client {
+	id := editor.language.Language_id
+	if id == "" || editor.file_name == "" || editor.read_only {
+		return nil
+	}
+	if !servers_started[id] {
+		start_language_server(id, editor.language.Language_server)
+	}
+	return servers[id]
+}
+
+// Start a server in the background, servers that aren't installed are skipped quietly
+func start_language_server(id string, command []string) {
+	servers_started[id] = true
+	if len(command) == 0 {
+		return
+	}
+	if _, err := exec.LookPath(command[0]); err != nil {
+		return
+	}
+	root, err := os.Getwd()
+	if err != nil {
+		return
+	}
+
+	go func() {
+		client, err := lsp.Start(command, root, on_server_notify)
+		post_event(func() {
+			if err != nil {
+				set_message("Couldn't start %s: %s", command[0], err)
+				return
+			}
+			servers[id] = client
+			open_documents(id)
+		})
+	}()
+}
+
+// Tell a server that has just started about the buffers in its language
+func open_documents(id string) {
+	sync_buffer()
+	for i := range editor.buffers {
+		b := &editor.buffers[i]
+		if b.language.Language_id != id || b.file_name == "" || b.read_only {
+			continue
+		}
+		servers[id].Did_open(b.file_name, id, b.version, buffer_text(b))
+		b.lsp_open = true
+		b.synced_version = b.version
+	}
+	editor.buffer_t = editor.buffers[editor.current]
+}
+
+// Handle what servers send without being asked, on the main goroutine
+func on_server_notify(method string, params json.RawMessage) {
+	if method != lsp.PUBLISH_DIAGNOSTICS {
+		return
+	}
+	post_event(func() {
+		var published lsp.Publish_diagnostics
+		if json.Unmarshal(params, &published) != nil {
+			return
+		}
+		i := find_buffer(lsp.Uri_path(published.Uri))
+		if i < 0 {
+			return
+		}
+		if i == editor.current {
+			editor.diagnostics = published.Diagnostics
+			diagnostic_row = -1
+		} else {
+			editor.buffers[i].diagnostics = published.Diagnostics
+		}
+	})
+}
+
+func document_opened() {
+	client := language_server()
+	if client == nil || editor.lsp_open {
+		return
+	}
+	b := buf{}
+	stringify(&b)
+	client.Did_open(editor.file_name, editor.language.Language_id, editor.version, string(b.buffer))
+	editor.lsp_open = true
+	editor.synced_version = editor.version
+}
+
+// Send the server the buffer's text if it has changed since it was last sent
+func sync_document() {
+	if !editor.lsp_open {
+		document_opened()
+		return
+	}
+	client := servers[editor.language.Language_id]
+	if client == nil || editor.version == editor.synced_version {
+		return
+	}
+	b := buf{}
+	stringify(&b)
+	client.Did_change(editor.file_name, editor.version, string(b.buffer))
+	editor.synced_version = editor.version
+}
+
+func document_saved() {
+	sync_document()
+	if client := servers[editor.language.Language_id]; client != nil && editor.lsp_open {
+		client.Did_save(editor.file_name)
+	}
+}
+
+func document_closed() {
+	if client := servers[editor.language.Language_id]; client != nil && editor.lsp_open {
+		client.Did_close(editor.file_name)
+	}
+	editor.lsp_open = false
+	editor.diagnostics = nil
+}
+
+// Shut the servers down, giving up on any that take too long
+func stop_language_servers() {
+	var wait sync.WaitGroup
+	for _, client := range servers {
+		wait.Add(1)
+		go func(client *lsp.Client) {
+			client.Close()
+			wait.Done()
+		}(client)
+	}
+	done := make(chan struct{})
+	go func() {
+		wait.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+	}
+}
+
+// The most severe diagnostic starting on a row, or nil if there isn't one
+func row_diagnostic(row uint) *lsp.Diagnostic {
+	var worst *lsp.Diagnostic
+	for i := range editor.diagnostics {
+		d := &editor.diagnostics[i]
+		if d.Range.Start.Line != int(row) {
+			continue
+		}
+		// servers can leave the severity out, which means an error
+		if worst == nil || d.Severity != 0 && (worst.Severity == 0 || d.Severity < worst.Severity) {
+			worst = d
+		}
+	}
+	return worst
+}
+
+func diagnostic_sign(row uint) (byte, byte) {
+	d := row_diagnostic(row)
+	if d == nil {
+		return 0, 0
+	}
+	switch d.Severity {
+	case lsp.SEVERITY_WARNING:
+		return 'W', T_YELLOW
+	case lsp.SEVERITY_INFO, lsp.SEVERITY_HINT:
+		return 'I', T_BLUE
+	}
+	return 'E', T_RED
+}
+
+// Say what's wrong with the row the cursor has moved onto, if anything
+func show_line_diagnostic() {
+	if int(editor.cursor.y) == diagnostic_row || editor.prompting {
+		return
+	}
+	diagnostic_row = int(editor.cursor.y)
+	if d := row_diagnostic(editor.cursor.y); d != nil {
+		if d.Source != "" {
+			set_message("%s: %s", d.Source, d.Message)
+		} else {
+			set_message("%s", d.Message)
+		}
+	}
+}
+
+// Where the cursor is, as the protocol counts it
+func cursor_position() lsp.Position {
+	var text []byte
+	if editor.cursor.y < editor.used_rows {
+		text = editor.lines[editor.cursor.y].text
+	}
+	return lsp.Position{Line: int(editor.cursor.y), Character: lsp.Utf16_column(text, int(editor.cursor.x))}
+}
+
+// Where a position the protocol gave is in the current buffer
+// Positions past the end, which a server that is behind can give, are kept inside it
+func buffer_position(pos lsp.Position) vector {
+	if editor.used_rows == 0 || pos.Line < 0 {
+		return vector{0, 0}
+	}
+	y := uint(pos.Line)
+	if y >= editor.used_rows {
+		return inside_buffer(vector{0, y})
+	}
+	return vector{uint(lsp.Byte_column(editor.lines[y].text, pos.Character)), y}
+}
+
+// The nearest place to a position that is in the buffer
+func inside_buffer(pos vector) vector {
+	if editor.used_rows == 0 {
+		return vector{0, 0}
+	}
+	if pos.y >= editor.used_rows {
+		pos.y = editor.used_rows - 1
+		pos.x = editor.lines[pos.y].len
+	}
+	if pos.x > editor.lines[pos.y].len {
+		pos.x = editor.lines[pos.y].len
+	}
+	return pos
+}
+
+// Get the server for the current buffer, saying why there isn't one
+func need_language_server() *lsp.Client {
+	client := language_server()
+	if client == nil {
+		if servers_started[editor.language.Language_id] && len(editor.language.Language_server) > 0 {
+			set_message("No language server running for %s", buffer_name(&editor.buffer_t))
+		} else {
+			set_message("No language server for %s", buffer_name(&editor.buffer_t))
+		}
+	}
+	return client
+}
+
+// Ask the server about whatever is under the cursor
+func hover() {
+	client := need_language_server()
+	if client == nil {
+		return
+	}
+	sync_document()
+	file, pos := editor.file_name, cursor_position()
+	go func() {
+		text, err := client.Hover(file, pos)
+		post_event(func() {
+			switch {
+			case err != nil:
+				set_message("Hover failed: %s", err)
+			case strings.TrimSpace(text) == "":
+				set_message("Nothing to say about that")
+			default:
+				set_message("%s", strings.Join(strings.Fields(text), " "))
+			}
+		})
+	}()
+}
+
+// Jump to where the server says the thing under the cursor is defined
+func lsp_definition(client *lsp.Client) {
+	sync_document()
+	file, pos := editor.file_name, cursor_position()
+	go func() {
+		found, err := client.Definition(file, pos)
+		post_after_prompt(func() {
+			if err != nil {
+				set_message("Couldn't find the definition: %s", err)
+				return
+			}
+			i := 0
+			switch {
+			case len(found) == 0:
+				set_message("No definition found")
+				return
+			case len(found) > 1:
+				items := make([]string, len(found))
+				for j, loc := range found {
+					items[j] = fmt.Sprintf("%s:%d", display_path(lsp.Uri_path(loc.Uri)), loc.Range.Start.Line+1)
+				}
+				if i = pick("Definitions: %s", items); i < 0 {
+					return
+				}
+			}
+			if open_jump(display_path(lsp.Uri_path(found[i].Uri))) {
+				pos := buffer_position(found[i].Range.Start)
+				set_cursor(pos.y, pos.x)
+			}
+		})
+	}()
+}
+
+// Complete what is being typed with what the server suggests
+func lsp_complete() {
+	client := need_language_server()
+	if client == nil || !writable() {
+		return
+	}
+	sync_document()
+	file, pos, cursor := editor.file_name, cursor_position(), editor.cursor
+	go func() {
+		items, err := client.Completion(file, pos)
+		post_after_prompt(func() {
+			// the user may have carried on typing or moved away while waiting
+			if editor.file_name != file || editor.cursor != cursor || editor.version != editor.synced_version {
+				return
+			}
+			if err != nil {
+				set_message("Completion failed: %s", err)
+				return
+			}
+			if len(items) == 0 {
+				set_message("No completions")
+				return
+			}
+			i := 0
+			if len(items) > 1 {
+				labels := make([]string, len(items))
+				for j, item := range items {
+					labels[j] = item.Label
+					if item.Detail != "" {
+						labels[j] += "  " + item.Detail
+					}
+				}
+				if i = pick("Complete: %s", labels); i < 0 {
+					return
+				}
+			}
+			apply_completion(items[i])
+		})
+	}()
+}
+
+// Put a completion in place of the word being typed, or where the server says
+func apply_completion(item lsp.Completion_item) {
+	text := item.Label
+	if item.Insert_text != "" {
+		text = item.Insert_text
+	}
+	start, _ := word_at_cursor()
+	from, to := inside_buffer(vector{start, editor.cursor.y}), inside_buffer(editor.cursor)
+	if item.Text_edit != nil {
+		text = item.Text_edit.New_text
+		from = buffer_position(item.Text_edit.Range.Start)
+		to = buffer_position(item.Text_edit.Range.End)
+		if to.y < from.y || to.y == from.y && to.x < from.x {
+			from, to = to, from
+		}
+	}
+	begin_edit(EDIT_OTHER)
+	after := replace_between(from, to, []byte(text))
+	end_edit()
+	set_cursor(after.y, after.x)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"editor/lsp"
+	"testing"
+)
+
+func TestBufferPosition(t *testing.T) {
+	editor.lines = []line_t{{text: []byte("héllo"), len: 6}, {text: []byte("x"), len: 1}}
+	editor.used_rows = 2
+	defer func() {
+		editor.lines = nil
+		editor.used_rows = 0
+	}()
+
+	tests := []struct {
+		pos  lsp.Position
+		want vector
+	}{
+		{lsp.Position{Line: 0, Character: 0}, vector{0, 0}},
+		{lsp.Position{Line: 0, Character: 2}, vector{3, 0}},
+		{lsp.Position{Line: 0, Character: 50}, vector{6, 0}},
+		{lsp.Position{Line: 1, Character: 1}, vector{1, 1}},
+		{lsp.Position{Line: 2, Character: 0}, vector{1, 1}},
+		{lsp.Position{Line: 900, Character: 3}, vector{1, 1}},
+		{lsp.Position{Line: -1, Character: 3}, vector{0, 0}},
+	}
+	for _, test := range tests {
+		if got := buffer_position(test.pos); got != test.want {
+			t.Errorf("buffer_position(%+v) = %v, want %v", test.pos, got, test.want)
+		}
+	}
+
+	editor.used_rows = 0
+	if got := buffer_position(lsp.Position{Line: 3, Character: 3}); got != (vector{0, 0}) {
+		t.Errorf("buffer_position in an empty buffer = %v, want the start", got)
+	}
+}
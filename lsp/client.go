@@ -0,0 +1,390 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// how long to wait for the server to answer a request
+var TIMEOUT = 5 * time.Second
+
+// A JSON-RPC message, which is a request, a response or a notification
+// depending on which fields are set
+type message struct {
+	Jsonrpc string           `json:"jsonrpc"`
+	Id      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  interface{}      `json:"params,omitempty"`
+	Result  json.RawMessage  `json:"result,omitempty"`
+	Error   *response_error  `json:"error,omitempty"`
+}
+
+type response_error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// What comes back from a request
+type response struct {
+	result json.RawMessage
+	err    error
+}
+
+// A connection to a language server
+// Notifications from the server are passed to On_notify on the goroutine
+// reading from the server, so it mustn't touch anything that isn't safe for that
+type Client struct {
+	On_notify func(method string, params json.RawMessage)
+
+	w io.Writer
+
+	lock    sync.Mutex
+	next_id int
+	pending map[int]chan response
+	closed  error
+
+	// messages waiting to be written, so sending never waits on the server
+	outgoing [][]byte
+	ready    *sync.Cond
+
+	cmd *exec.Cmd
+}
+
+// Talk to a server over a reader and writer, which is all a client needs
+// so it can be run against anything that speaks the protocol
+func New_client(r io.Reader, w io.Writer, on_notify func(string, json.RawMessage)) *Client {
+	c := &Client{
+		On_notify: on_notify,
+		w:         w,
+		pending:   map[int]chan response{},
+	}
+	c.ready = sync.NewCond(&c.lock)
+	go c.read_loop(bufio.NewReader(r))
+	go c.write_loop()
+	return c
+}
+
+// Start a server and initialize it, with its stdin and stdout as the connection
+// This waits for the server to answer, so callers shouldn't do it on the main goroutine
+func Start(command []string, root string, on_notify func(string, json.RawMessage)) (*Client, error) {
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Dir = root
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	c := New_client(stdout, stdin, on_notify)
+	c.cmd = cmd
+	if err := c.Initialize(root); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Read one message, which comes after headers giving its length
+func read_message(r *bufio.Reader) ([]byte, error) {
+	length := -1
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		name, value, found := strings.Cut(line, ":")
+		if found && strings.EqualFold(name, "Content-Length") {
+			length, err = strconv.Atoi(strings.TrimSpace(value))
+			if err != nil {
+				return nil, fmt.Errorf("bad Content-Length: %q", value)
+			}
+		}
+	}
+	if length < 0 {
+		return nil, errors.New("message without a Content-Length")
+	}
+	body := make([]byte, length)
+	_, err := io.ReadFull(r, body)
+	return body, err
+}
+
+// Queue a message to be written, failing only if the connection has closed
+func (c *Client) write(msg message) error {
+	msg.Jsonrpc = "2.0"
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed != nil {
+		return c.closed
+	}
+	c.outgoing = append(c.outgoing, []byte(fmt.Sprintf("Content-Length: %d\r\n\r\n%s", len(body), body)))
+	c.ready.Signal()
+	return nil
+}
+
+// Write queued messages in order until the connection closes
+func (c *Client) write_loop() {
+	for {
+		c.lock.Lock()
+		for len(c.outgoing) == 0 && c.closed == nil {
+			c.ready.Wait()
+		}
+		if c.closed != nil {
+			c.lock.Unlock()
+			return
+		}
+		queued := c.outgoing
+		c.outgoing = nil
+		c.lock.Unlock()
+
+		for _, data := range queued {
+			if _, err := c.w.Write(data); err != nil {
+				c.fail(err)
+				return
+			}
+		}
+	}
+}
+
+// Close the connection, failing whatever is still waiting and anything sent from now on
+func (c *Client) fail(err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed != nil {
+		return
+	}
+	c.closed = fmt.Errorf("language server connection closed: %w", err)
+	for id, ch := range c.pending {
+		ch <- response{err: c.closed}
+		delete(c.pending, id)
+	}
+	c.outgoing = nil
+	c.ready.Broadcast()
+}
+
+// Handle everything the server sends until the connection closes
+func (c *Client) read_loop(r *bufio.Reader) {
+	var err error
+	for {
+		var body []byte
+		body, err = read_message(r)
+		if err != nil {
+			break
+		}
+		var msg struct {
+			Id     *json.RawMessage `json:"id"`
+			Method string           `json:"method"`
+			Params json.RawMessage  `json:"params"`
+			Result json.RawMessage  `json:"result"`
+			Error  *response_error  `json:"error"`
+		}
+		if json.Unmarshal(body, &msg) != nil {
+			continue
+		}
+
+		switch {
+		case msg.Method != "" && msg.Id != nil:
+			// answered separately so a server that won't read until it's
+			// done writing can't block this loop
+			go c.answer_request(msg.Id, msg.Method, msg.Params)
+		case msg.Method != "":
+			if c.On_notify != nil {
+				c.On_notify(msg.Method, msg.Params)
+			}
+		case msg.Id != nil:
+			id, _ := strconv.Atoi(string(*msg.Id))
+			res := response{result: msg.Result}
+			if msg.Error != nil {
+				res.err = fmt.Errorf("%s (%d)", msg.Error.Message, msg.Error.Code)
+			}
+			c.lock.Lock()
+			ch := c.pending[id]
+			delete(c.pending, id)
+			c.lock.Unlock()
+			if ch != nil {
+				ch <- res
+			}
+		}
+	}
+
+	c.fail(err)
+}
+
+// Servers ask things of the client too, which all get an empty answer
+// apart from configuration, which gets one empty setting per item asked for
+func (c *Client) answer_request(id *json.RawMessage, method string, params json.RawMessage) {
+	var result interface{}
+	if method == "workspace/configuration" {
+		var config struct {
+			Items []json.RawMessage `json:"items"`
+		}
+		json.Unmarshal(params, &config)
+		result = make([]interface{}, len(config.Items))
+	}
+	raw, _ := json.Marshal(result)
+	c.write(message{Id: id, Result: raw})
+}
+
+// Send a request and wait for the answer, decoding it into result if it isn't nil
+func (c *Client) Call(method string, params interface{}, result interface{}) error {
+	ch := make(chan response, 1)
+	c.lock.Lock()
+	if c.closed != nil {
+		c.lock.Unlock()
+		return c.closed
+	}
+	c.next_id++
+	id := c.next_id
+	c.pending[id] = ch
+	c.lock.Unlock()
+
+	raw := json.RawMessage(strconv.Itoa(id))
+	if err := c.write(message{Id: &raw, Method: method, Params: params}); err != nil {
+		c.lock.Lock()
+		delete(c.pending, id)
+		c.lock.Unlock()
+		return err
+	}
+
+	select {
+	case res := <-ch:
+		if res.err != nil {
+			return res.err
+		}
+		if result != nil && len(res.result) > 0 {
+			return json.Unmarshal(res.result, result)
+		}
+		return nil
+	case <-time.After(TIMEOUT):
+		c.lock.Lock()
+		delete(c.pending, id)
+		c.lock.Unlock()
+		return fmt.Errorf("%s timed out", method)
+	}
+}
+
+// Send a notification, which has no answer
+func (c *Client) Notify(method string, params interface{}) error {
+	return c.write(message{Method: method, Params: params})
+}
+
+func (c *Client) Initialize(root string) error {
+	params := map[string]interface{}{
+		"processId": os.Getpid(),
+		"rootUri":   File_uri(root),
+		"capabilities": map[string]interface{}{
+			"textDocument": map[string]interface{}{
+				"synchronization":    map[string]interface{}{"didSave": true},
+				"hover":              map[string]interface{}{"contentFormat": []string{"plaintext"}},
+				"completion":         map[string]interface{}{"completionItem": map[string]interface{}{"snippetSupport": false}},
+				"definition":         map[string]interface{}{},
+				"publishDiagnostics": map[string]interface{}{},
+			},
+		},
+	}
+	if err := c.Call("initialize", params, nil); err != nil {
+		return err
+	}
+	return c.Notify("initialized", map[string]interface{}{})
+}
+
+// Ask the server to stop, and stop it if it was started by Start
+func (c *Client) Close() {
+	c.Call("shutdown", nil, nil)
+	c.Notify("exit", nil)
+	if c.cmd != nil {
+		done := make(chan struct{})
+		go func() {
+			c.cmd.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			c.cmd.Process.Kill()
+			<-done
+		}
+	}
+}
+
+func (c *Client) Did_open(path string, language_id string, version int, text string) error {
+	return c.Notify("textDocument/didOpen", map[string]interface{}{
+		"textDocument": text_document_item{File_uri(path), language_id, version, text},
+	})
+}
+
+// The whole text is sent with every change, so servers needn't support incremental changes
+func (c *Client) Did_change(path string, version int, text string) error {
+	return c.Notify("textDocument/didChange", map[string]interface{}{
+		"textDocument":   versioned_document{File_uri(path), version},
+		"contentChanges": []map[string]string{{"text": text}},
+	})
+}
+
+func (c *Client) Did_save(path string) error {
+	return c.Notify("textDocument/didSave", map[string]interface{}{
+		"textDocument": text_document{Uri: File_uri(path)},
+	})
+}
+
+func (c *Client) Did_close(path string) error {
+	return c.Notify("textDocument/didClose", map[string]interface{}{
+		"textDocument": text_document{Uri: File_uri(path)},
+	})
+}
+
+func position_in(path string, pos Position) position_params {
+	return position_params{text_document{Uri: File_uri(path)}, pos}
+}
+
+// Text describing what is at a position, empty if there's nothing to say
+func (c *Client) Hover(path string, pos Position) (string, error) {
+	var result *struct {
+		Contents json.RawMessage `json:"contents"`
+	}
+	if err := c.Call("textDocument/hover", position_in(path, pos), &result); err != nil || result == nil {
+		return "", err
+	}
+	return hover_text(result.Contents), nil
+}
+
+// Where the thing at a position is defined
+func (c *Client) Definition(path string, pos Position) ([]Location, error) {
+	var result json.RawMessage
+	if err := c.Call("textDocument/definition", position_in(path, pos), &result); err != nil {
+		return nil, err
+	}
+	return parse_locations(result), nil
+}
+
+// What could be typed at a position
+func (c *Client) Completion(path string, pos Position) ([]Completion_item, error) {
+	var result json.RawMessage
+	if err := c.Call("textDocument/completion", position_in(path, pos), &result); err != nil {
+		return nil, err
+	}
+	return parse_completions(result), nil
+}
@@ -0,0 +1,380 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The test binary runs itself as a language server when this is set to how
+// the server should behave, so Start can be tested against a real process
+const FAKE_SERVER = "LSP_FAKE_SERVER"
+
+func TestMain(m *testing.M) {
+	if mode := os.Getenv(FAKE_SERVER); mode != "" {
+		run_fake_server(mode)
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// Serve over stdin and stdout
+// "ok" answers everything, hovering gives the directory it was started in,
+// "refuse" fails to initialize and "stubborn" won't exit when told to
+func run_fake_server(mode string) {
+	r := bufio.NewReader(os.Stdin)
+	for {
+		body, err := read_message(r)
+		if err != nil {
+			return
+		}
+		var msg received
+		json.Unmarshal(body, &msg)
+		answer := "null"
+		switch msg.Method {
+		case "initialize":
+			if mode == "refuse" {
+				reply := fmt.Sprintf(`{"jsonrpc":"2.0","id":%s,"error":{"code":-32603,"message":"no thanks"}}`, *msg.Id)
+				fmt.Printf("Content-Length: %d\r\n\r\n%s", len(reply), reply)
+				continue
+			}
+			answer = `{"capabilities":{}}`
+		case "textDocument/hover":
+			dir, _ := os.Getwd()
+			contents, _ := json.Marshal(dir)
+			answer = `{"contents":` + string(contents) + `}`
+		case "exit":
+			if mode != "stubborn" {
+				return
+			}
+		}
+		if msg.Id != nil {
+			reply := fmt.Sprintf(`{"jsonrpc":"2.0","id":%s,"result":%s}`, *msg.Id, answer)
+			fmt.Printf("Content-Length: %d\r\n\r\n%s", len(reply), reply)
+		}
+	}
+}
+
+// Start the test binary as a server behaving some way
+func start_process(t *testing.T, mode string, root string) (*Client, error) {
+	t.Setenv(FAKE_SERVER, mode)
+	// under the race detector a process lingers for a second after exiting
+	t.Setenv("GORACE", "atexit_sleep_ms=0")
+	return Start([]string{os.Args[0], "-test.run=^$"}, root, nil)
+}
+
+func TestStart(t *testing.T) {
+	root := t.TempDir()
+	c, err := start_process(t, "ok", root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the server runs in the project's root
+	dir, err := c.Hover(filepath.Join(root, "main.go"), Position{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := filepath.EvalSymlinks(root)
+	if got, _ := filepath.EvalSymlinks(dir); got != want {
+		t.Errorf("server ran in %q, want %q", dir, root)
+	}
+
+	start := time.Now()
+	c.Close()
+	if c.cmd.ProcessState == nil || !c.cmd.ProcessState.Success() {
+		t.Errorf("server didn't exit cleanly: %v", c.cmd.ProcessState)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Errorf("closing took %v", time.Since(start))
+	}
+}
+
+func TestStartFailures(t *testing.T) {
+	if _, err := Start([]string{filepath.Join(t.TempDir(), "no-such-server")}, ".", nil); err == nil {
+		t.Error("starting a server that doesn't exist worked")
+	}
+	c, err := start_process(t, "refuse", t.TempDir())
+	if err == nil || !strings.Contains(err.Error(), "no thanks") {
+		t.Errorf("a server that wouldn't initialize gave %v, %v", c, err)
+	}
+}
+
+// A server that won't exit is killed
+func TestCloseKills(t *testing.T) {
+	c, err := start_process(t, "stubborn", t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	c.Close()
+	if time.Since(start) > 3*time.Second {
+		t.Errorf("closing took %v", time.Since(start))
+	}
+	if c.cmd.ProcessState == nil || c.cmd.ProcessState.Success() {
+		t.Errorf("stubborn server wasn't killed: %v", c.cmd.ProcessState)
+	}
+}
+
+// A message as the fake server sees it
+type received struct {
+	Id     *json.RawMessage `json:"id"`
+	Method string           `json:"method"`
+	Params json.RawMessage  `json:"params"`
+	Result json.RawMessage  `json:"result"`
+}
+
+// A server on the other end of a pair of pipes, answering requests
+// with canned results by method and passing on everything it gets
+type fake_server struct {
+	w       io.Writer
+	answers map[string]string
+	got     chan received
+}
+
+func (s *fake_server) send(msg string) {
+	fmt.Fprintf(s.w, "Content-Length: %d\r\n\r\n%s", len(msg), msg)
+}
+
+func (s *fake_server) serve(r *bufio.Reader) {
+	for {
+		body, err := read_message(r)
+		if err != nil {
+			close(s.got)
+			return
+		}
+		var msg received
+		json.Unmarshal(body, &msg)
+		// requests without a canned answer are left for the test to answer
+		if answer, ok := s.answers[msg.Method]; ok && msg.Id != nil {
+			s.send(fmt.Sprintf(`{"jsonrpc":"2.0","id":%s,"result":%s}`, *msg.Id, answer))
+		}
+		s.got <- msg
+	}
+}
+
+// What the server is sent next, failing if it's something else
+func (s *fake_server) expect(t *testing.T, method string) received {
+	t.Helper()
+	select {
+	case msg := <-s.got:
+		if msg.Method != method {
+			t.Fatalf("server got %q, want %q", msg.Method, method)
+		}
+		return msg
+	case <-time.After(time.Second):
+		t.Fatalf("server never got %q", method)
+	}
+	return received{}
+}
+
+func start_fake(t *testing.T, answers map[string]string, on_notify func(string, json.RawMessage)) (*Client, *fake_server) {
+	to_client_r, to_client_w := io.Pipe()
+	to_server_r, to_server_w := io.Pipe()
+	t.Cleanup(func() {
+		to_client_w.Close()
+		to_server_w.Close()
+	})
+	server := &fake_server{w: to_client_w, answers: answers, got: make(chan received, 100)}
+	go server.serve(bufio.NewReader(to_server_r))
+	return New_client(to_client_r, to_server_w, on_notify), server
+}
+
+func TestInitialize(t *testing.T) {
+	c, server := start_fake(t, map[string]string{"initialize": `{"capabilities":{}}`}, nil)
+	if err := c.Initialize("/project"); err != nil {
+		t.Fatal(err)
+	}
+	init := server.expect(t, "initialize")
+	var params struct {
+		Root_uri string `json:"rootUri"`
+	}
+	json.Unmarshal(init.Params, &params)
+	if params.Root_uri != "file:///project" {
+		t.Errorf("rootUri = %q, want file:///project", params.Root_uri)
+	}
+	server.expect(t, "initialized")
+}
+
+func TestDocumentSync(t *testing.T) {
+	c, server := start_fake(t, nil, nil)
+
+	// an empty document still has a version and text
+	c.Did_open("/project/empty.go", "go", 0, "")
+	open := server.expect(t, "textDocument/didOpen")
+	want := `{"textDocument":{"uri":"file:///project/empty.go","languageId":"go","version":0,"text":""}}`
+	if string(open.Params) != want {
+		t.Errorf("didOpen params = %s, want %s", open.Params, want)
+	}
+
+	c.Did_change("/project/empty.go", 3, "package main\n")
+	change := server.expect(t, "textDocument/didChange")
+	want = `{"contentChanges":[{"text":"package main\n"}],"textDocument":{"uri":"file:///project/empty.go","version":3}}`
+	if string(change.Params) != want {
+		t.Errorf("didChange params = %s, want %s", change.Params, want)
+	}
+
+	c.Did_save("/project/empty.go")
+	server.expect(t, "textDocument/didSave")
+	c.Did_close("/project/empty.go")
+	server.expect(t, "textDocument/didClose")
+}
+
+func TestPublishDiagnostics(t *testing.T) {
+	notified := make(chan Publish_diagnostics, 1)
+	_, server := start_fake(t, nil, func(method string, params json.RawMessage) {
+		if method != PUBLISH_DIAGNOSTICS {
+			return
+		}
+		var published Publish_diagnostics
+		json.Unmarshal(params, &published)
+		notified <- published
+	})
+	server.send(`{"jsonrpc":"2.0","method":"textDocument/publishDiagnostics","params":{"uri":"file:///project/main.go",` +
+		`"diagnostics":[{"range":{"start":{"line":4,"character":1},"end":{"line":4,"character":5}},"severity":1,"message":"undefined: x"}]}}`)
+
+	select {
+	case published := <-notified:
+		want := Publish_diagnostics{"file:///project/main.go", []Diagnostic{
+			{Range{Position{4, 1}, Position{4, 5}}, SEVERITY_ERROR, "", "undefined: x"},
+		}}
+		if !reflect.DeepEqual(published, want) {
+			t.Errorf("got %+v, want %+v", published, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("diagnostics never arrived")
+	}
+}
+
+func TestServerRequest(t *testing.T) {
+	_, server := start_fake(t, nil, nil)
+	server.send(`{"jsonrpc":"2.0","id":7,"method":"workspace/configuration","params":{"items":[{},{}]}}`)
+	select {
+	case msg := <-server.got:
+		if msg.Id == nil || string(*msg.Id) != "7" || string(msg.Result) != "[null,null]" {
+			t.Errorf("answered with id %s and result %s, want 7 and [null,null]", msg.Id, msg.Result)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the request was never answered")
+	}
+}
+
+func TestHover(t *testing.T) {
+	tests := []struct {
+		answer, want string
+	}{
+		{`{"contents":{"kind":"plaintext","value":"func main()"}}`, "func main()"},
+		{`{"contents":"var x int"}`, "var x int"},
+		{`{"contents":[{"language":"go","value":"type T"},"T is a thing"]}`, "type T\nT is a thing"},
+		{`null`, ""},
+	}
+	for _, test := range tests {
+		c, server := start_fake(t, map[string]string{"textDocument/hover": test.answer}, nil)
+		text, err := c.Hover("/project/main.go", Position{2, 6})
+		if err != nil || text != test.want {
+			t.Errorf("hover answered with %s gave %q, %v, want %q", test.answer, text, err, test.want)
+		}
+		msg := server.expect(t, "textDocument/hover")
+		want := `{"textDocument":{"uri":"file:///project/main.go"},"position":{"line":2,"character":6}}`
+		if string(msg.Params) != want {
+			t.Errorf("hover params = %s, want %s", msg.Params, want)
+		}
+	}
+}
+
+func TestDefinition(t *testing.T) {
+	here := Range{Position{1, 5}, Position{1, 9}}
+	tests := []struct {
+		answer string
+		want   []Location
+	}{
+		{`{"uri":"file:///a.go","range":{"start":{"line":1,"character":5},"end":{"line":1,"character":9}}}`,
+			[]Location{{"file:///a.go", here}}},
+		{`[{"uri":"file:///a.go","range":{"start":{"line":1,"character":5},"end":{"line":1,"character":9}}},{"uri":"file:///b.go","range":{"start":{"line":0,"character":0},"end":{"line":0,"character":0}}}]`,
+			[]Location{{"file:///a.go", here}, {"file:///b.go", Range{}}}},
+		{`[{"targetUri":"file:///a.go","targetSelectionRange":{"start":{"line":1,"character":5},"end":{"line":1,"character":9}}}]`,
+			[]Location{{"file:///a.go", here}}},
+		{`null`, nil},
+	}
+	for _, test := range tests {
+		c, _ := start_fake(t, map[string]string{"textDocument/definition": test.answer}, nil)
+		found, err := c.Definition("/project/main.go", Position{})
+		if err != nil || !reflect.DeepEqual(found, test.want) {
+			t.Errorf("definition answered with %s gave %+v, %v, want %+v", test.answer, found, err, test.want)
+		}
+	}
+}
+
+func TestCompletion(t *testing.T) {
+	items := `[{"label":"Println","detail":"func(a ...any)"},{"label":"Printf","insertText":"Printf(",` +
+		`"textEdit":{"range":{"start":{"line":3,"character":5},"end":{"line":3,"character":8}},"newText":"Printf"}}]`
+	want := []Completion_item{
+		{Label: "Println", Detail: "func(a ...any)"},
+		{Label: "Printf", Insert_text: "Printf(", Text_edit: &Text_edit{Range{Position{3, 5}, Position{3, 8}}, "Printf"}},
+	}
+	for _, answer := range []string{items, `{"isIncomplete":false,"items":` + items + `}`} {
+		c, _ := start_fake(t, map[string]string{"textDocument/completion": answer}, nil)
+		found, err := c.Completion("/project/main.go", Position{3, 8})
+		if err != nil || !reflect.DeepEqual(found, want) {
+			t.Errorf("completion answered with %s gave %+v, %v", answer, found, err)
+		}
+	}
+}
+
+// A server that goes away fails what is waiting and everything sent after it
+func TestClosedConnection(t *testing.T) {
+	r, w := io.Pipe()
+	c := New_client(r, io.Discard, nil)
+	done := make(chan error, 1)
+	go func() { done <- c.Call("textDocument/hover", nil, nil) }()
+	time.Sleep(10 * time.Millisecond)
+	w.Close()
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "closed") {
+			t.Errorf("call on a closed connection gave %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the call was never failed")
+	}
+	if err := c.Notify("exit", nil); err == nil {
+		t.Error("notify after the connection closed didn't fail")
+	}
+}
+
+func TestErrorResponse(t *testing.T) {
+	c, server := start_fake(t, nil, nil)
+	go func() {
+		msg := <-server.got
+		server.send(fmt.Sprintf(`{"jsonrpc":"2.0","id":%s,"error":{"code":-32601,"message":"no such method"}}`, *msg.Id))
+	}()
+	err := c.Call("nonsense", nil, nil)
+	if err == nil || err.Error() != "no such method (-32601)" {
+		t.Errorf("got %v, want the server's error", err)
+	}
+}
+
+// Writing to a server that isn't reading must not hold up the caller
+func TestWritesDontBlock(t *testing.T) {
+	_, stalled := io.Pipe()
+	r, _ := io.Pipe()
+	c := New_client(r, stalled, nil)
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 100; i++ {
+			c.Did_change("/project/main.go", i, strings.Repeat("x", 1000))
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("sending blocked on a server that wasn't reading")
+	}
+}
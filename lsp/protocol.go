@@ -0,0 +1,194 @@
+package lsp
+
+import (
+	"encoding/json"
+	"net/url"
+	"path/filepath"
+	"unicode/utf8"
+)
+
+// The parts of the protocol the editor uses
+// Positions count lines from 0 and characters in UTF-16 code units
+
+type Position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type Range struct {
+	Start Position `json:"start"`
+	End   Position `json:"end"`
+}
+
+type Location struct {
+	Uri   string `json:"uri"`
+	Range Range  `json:"range"`
+}
+
+const (
+	SEVERITY_ERROR   = 1
+	SEVERITY_WARNING = 2
+	SEVERITY_INFO    = 3
+	SEVERITY_HINT    = 4
+)
+
+type Diagnostic struct {
+	Range    Range  `json:"range"`
+	Severity int    `json:"severity"`
+	Source   string `json:"source"`
+	Message  string `json:"message"`
+}
+
+type Text_edit struct {
+	Range    Range  `json:"range"`
+	New_text string `json:"newText"`
+}
+
+type Completion_item struct {
+	Label       string     `json:"label"`
+	Detail      string     `json:"detail"`
+	Insert_text string     `json:"insertText"`
+	Text_edit   *Text_edit `json:"textEdit"`
+}
+
+// How documents are named in requests and notifications about them
+type text_document struct {
+	Uri string `json:"uri"`
+}
+
+// A document as it is after a change
+type versioned_document struct {
+	Uri     string `json:"uri"`
+	Version int    `json:"version"`
+}
+
+// A document being opened, all of which the server needs even when empty
+type text_document_item struct {
+	Uri         string `json:"uri"`
+	Language_id string `json:"languageId"`
+	Version     int    `json:"version"`
+	Text        string `json:"text"`
+}
+
+type position_params struct {
+	Text_document text_document `json:"textDocument"`
+	Position      Position      `json:"position"`
+}
+
+type Publish_diagnostics struct {
+	Uri         string       `json:"uri"`
+	Diagnostics []Diagnostic `json:"diagnostics"`
+}
+
+// Name of the notification servers send diagnostics in
+const PUBLISH_DIAGNOSTICS = "textDocument/publishDiagnostics"
+
+func File_uri(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+}
+
+// The path of a file:// uri, or the uri itself if it isn't one
+func Uri_path(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	return filepath.FromSlash(u.Path)
+}
+
+// Column in UTF-16 code units of a byte offset into a line
+func Utf16_column(line []byte, x int) int {
+	col := 0
+	for i := 0; i < x && i < len(line); {
+		r, size := utf8.DecodeRune(line[i:])
+		i += size
+		col++
+		if r >= 0x10000 {
+			col++
+		}
+	}
+	return col
+}
+
+// Byte offset into a line of a column in UTF-16 code units
+func Byte_column(line []byte, col int) int {
+	i := 0
+	for col > 0 && i < len(line) {
+		r, size := utf8.DecodeRune(line[i:])
+		i += size
+		col--
+		if r >= 0x10000 {
+			col--
+		}
+	}
+	return i
+}
+
+// Hover contents come as markup, a string, a {language, value} pair or a list of those
+func hover_text(raw json.RawMessage) string {
+	var markup struct {
+		Value string `json:"value"`
+	}
+	if json.Unmarshal(raw, &markup) == nil && markup.Value != "" {
+		return markup.Value
+	}
+	var text string
+	if json.Unmarshal(raw, &text) == nil {
+		return text
+	}
+	var list []json.RawMessage
+	if json.Unmarshal(raw, &list) == nil {
+		all := ""
+		for _, item := range list {
+			if part := hover_text(item); part != "" {
+				if all != "" {
+					all += "\n"
+				}
+				all += part
+			}
+		}
+		return all
+	}
+	return ""
+}
+
+// Definitions come as a location, a list of them, or a list of links
+func parse_locations(raw json.RawMessage) []Location {
+	var one Location
+	if json.Unmarshal(raw, &one) == nil && one.Uri != "" {
+		return []Location{one}
+	}
+	var links []struct {
+		Location
+		Target_uri   string `json:"targetUri"`
+		Target_range Range  `json:"targetSelectionRange"`
+	}
+	if json.Unmarshal(raw, &links) != nil {
+		return nil
+	}
+	var found []Location
+	for _, link := range links {
+		if link.Uri != "" {
+			found = append(found, link.Location)
+		} else if link.Target_uri != "" {
+			found = append(found, Location{link.Target_uri, link.Target_range})
+		}
+	}
+	return found
+}
+
+// Completions come as a list of items or an object holding one
+func parse_completions(raw json.RawMessage) []Completion_item {
+	var items []Completion_item
+	if json.Unmarshal(raw, &items) == nil {
+		return items
+	}
+	var list struct {
+		Items []Completion_item `json:"items"`
+	}
+	json.Unmarshal(raw, &list)
+	return list.Items
+}
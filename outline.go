@@ -0,0 +1,292 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"strings"
+)
+
+// A declaration found in Go source
+type go_decl struct {
+	kind string // func, method, type, field, var or const
+	recv string // type a method or field belongs to
+	name string
+	loc  file_loc
+}
+
+// How a declaration is shown in lists
+func (d go_decl) String() string {
+	switch d.kind {
+	case "method":
+		return fmt.Sprintf("func (%s) %s", d.recv, d.name)
+	case "field":
+		return fmt.Sprintf("field %s.%s", d.recv, d.name)
+	}
+	return d.kind + " " + d.name
+}
+
+func is_go_file() bool {
+	return filepath.Ext(editor.file_name) == ".go"
+}
+
+func loc_of(fset *token.FileSet, pos token.Pos) file_loc {
+	p := fset.Position(pos)
+	return file_loc{p.Filename, uint(p.Line - 1), uint(p.Column - 1)}
+}
+
+// Parse the current buffer rather than the file, which may be out of date
+// As much as could be parsed is returned even if there were errors
+func parse_buffer(fset *token.FileSet) *ast.File {
+	b := buf{}
+	stringify(&b)
+	f, _ := parser.ParseFile(fset, editor.file_name, b.buffer, parser.SkipObjectResolution)
+	return f
+}
+
+// Name of the type a method's receiver is, without any pointer or type parameters
+func receiver_name(expr ast.Expr) string {
+	switch t := expr.(type) {
+	case *ast.StarExpr:
+		return receiver_name(t.X)
+	case *ast.IndexExpr:
+		return receiver_name(t.X)
+	case *ast.IndexListExpr:
+		return receiver_name(t.X)
+	case *ast.Ident:
+		return t.Name
+	}
+	return "?"
+}
+
+// The top level declarations of a file, in order, along with the fields
+// and methods of the types declared in it
+func go_decls(fset *token.FileSet, f *ast.File) []go_decl {
+	var decls []go_decl
+	add := func(kind string, recv string, id *ast.Ident) {
+		if id != nil && id.Name != "_" {
+			decls = append(decls, go_decl{kind, recv, id.Name, loc_of(fset, id.Pos())})
+		}
+	}
+	for _, decl := range f.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			if decl.Recv != nil && len(decl.Recv.List) > 0 {
+				add("method", receiver_name(decl.Recv.List[0].Type), decl.Name)
+			} else {
+				add("func", "", decl.Name)
+			}
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				switch spec := spec.(type) {
+				case *ast.TypeSpec:
+					add("type", "", spec.Name)
+					var fields *ast.FieldList
+					switch t := spec.Type.(type) {
+					case *ast.StructType:
+						fields = t.Fields
+					case *ast.InterfaceType:
+						fields = t.Methods
+					}
+					if fields == nil {
+						continue
+					}
+					for _, field := range fields.List {
+						for _, name := range field.Names {
+							add("field", spec.Name.Name, name)
+						}
+					}
+				case *ast.ValueSpec:
+					for _, name := range spec.Names {
+						add(decl.Tok.String(), "", name)
+					}
+				}
+			}
+		}
+	}
+	return decls
+}
+
+// Pick a function, method, type, var or const in the file and jump to it
+func go_outline() {
+	if !is_go_file() {
+		set_message("The outline is only for Go files")
+		return
+	}
+	fset := token.NewFileSet()
+	f := parse_buffer(fset)
+
+	var decls []go_decl
+	var items []string
+	for _, d := range go_decls(fset, f) {
+		if d.kind == "field" {
+			continue
+		}
+		decls = append(decls, d)
+		items = append(items, fmt.Sprintf("%s  :%d", d, d.loc.y+1))
+	}
+	if len(decls) == 0 {
+		set_message("Nothing declared in %s", editor.file_name)
+		return
+	}
+	if i := pick("Outline: %s", items); i >= 0 {
+		push_jump()
+		set_cursor(decls[i].loc.y, decls[i].loc.x)
+	}
+}
+
+// Offset of the cursor into the buffer's text
+func cursor_offset() int {
+	offset := editor.cursor.x
+	for y := uint(0); y < editor.cursor.y && y < editor.used_rows; y++ {
+		offset += editor.lines[y].len + 1
+	}
+	return int(offset)
+}
+
+// The closest declaration of a name before the cursor, in the functions
+// and blocks the cursor is inside
+func local_decl(fset *token.FileSet, f *ast.File, name string) (file_loc, bool) {
+	// without a package clause the parser gives up before anything has a position
+	if f.Package == token.NoPos || fset.File(f.Package) == nil {
+		return file_loc{}, false
+	}
+	cursor := fset.File(f.Package).Pos(cursor_offset())
+	var best *ast.Ident
+	consider := func(expr ast.Expr) {
+		id, ok := expr.(*ast.Ident)
+		if ok && id.Name == name && id.Pos() <= cursor && (best == nil || id.Pos() > best.Pos()) {
+			best = id
+		}
+	}
+	consider_fields := func(fields *ast.FieldList) {
+		if fields == nil {
+			return
+		}
+		for _, field := range fields.List {
+			for _, id := range field.Names {
+				consider(id)
+			}
+		}
+	}
+
+	ast.Inspect(f, func(n ast.Node) bool {
+		if n == nil {
+			return false
+		}
+		// declarations are seen by anything after them in the same block,
+		// but what's inside a block is only seen from inside it
+		contains := n.Pos() <= cursor && cursor <= n.End()
+		switch n := n.(type) {
+		case *ast.GenDecl:
+			// top level declarations are left for the package wide search
+			return false
+		case *ast.AssignStmt:
+			if n.Tok == token.DEFINE {
+				for _, lhs := range n.Lhs {
+					consider(lhs)
+				}
+			}
+		case *ast.DeclStmt:
+			if decl, ok := n.Decl.(*ast.GenDecl); ok {
+				for _, spec := range decl.Specs {
+					switch spec := spec.(type) {
+					case *ast.ValueSpec:
+						for _, id := range spec.Names {
+							consider(id)
+						}
+					case *ast.TypeSpec:
+						consider(spec.Name)
+					}
+				}
+			}
+		case *ast.RangeStmt:
+			if contains && n.Tok == token.DEFINE {
+				consider(n.Key)
+				consider(n.Value)
+			}
+		case *ast.FuncDecl:
+			if contains {
+				consider_fields(n.Recv)
+				consider_fields(n.Type.TypeParams)
+				consider_fields(n.Type.Params)
+				consider_fields(n.Type.Results)
+			}
+		case *ast.FuncLit:
+			if contains {
+				consider_fields(n.Type.Params)
+				consider_fields(n.Type.Results)
+			}
+		}
+		return contains
+	})
+	if best == nil {
+		return file_loc{}, false
+	}
+	return loc_of(fset, best.Pos()), true
+}
+
+// Jump to where the identifier under the cursor is declared, looking in the
+// function the cursor is in and then the rest of the package's directory
+// If it's declared more than once, say as methods of different types,
+// the user picks which
+func go_definition() {
+	name := cursor_word()
+	if name == "" {
+		set_message("No identifier under the cursor")
+		return
+	}
+	fset := token.NewFileSet()
+	f := parse_buffer(fset)
+	if loc, ok := local_decl(fset, f, name); ok {
+		push_jump()
+		set_cursor(loc.y, loc.x)
+		return
+	}
+
+	var found []go_decl
+	collect := func(f *ast.File) {
+		for _, d := range go_decls(fset, f) {
+			if d.name == name {
+				found = append(found, d)
+			}
+		}
+	}
+	collect(f)
+	// external test packages can see the package they test
+	pkg := strings.TrimSuffix(f.Name.Name, "_test")
+	paths, _ := filepath.Glob(filepath.Join(filepath.Dir(editor.file_name), "*.go"))
+	for _, path := range paths {
+		if same_file(path, editor.file_name) {
+			continue
+		}
+		other, _ := parser.ParseFile(fset, path, nil, parser.SkipObjectResolution)
+		if other != nil && strings.TrimSuffix(other.Name.Name, "_test") == pkg {
+			collect(other)
+		}
+	}
+	choose_definition(name, found)
+}
+
+// Jump to one of the places a name is declared, asking which if there are several
+func choose_definition(name string, found []go_decl) {
+	i := 0
+	switch {
+	case len(found) == 0:
+		set_message("No declaration of %s found", name)
+		return
+	case len(found) > 1:
+		items := make([]string, len(found))
+		for j, d := range found {
+			items[j] = fmt.Sprintf("%s  %s:%d", d, d.loc.file, d.loc.y+1)
+		}
+		if i = pick(fmt.Sprintf("%d declarations of %s: %%s", len(found), name), items); i < 0 {
+			return
+		}
+	}
+	if open_jump(found[i].loc.file) {
+		set_cursor(found[i].loc.y, found[i].loc.x)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+)
+
+func TestLocalDecl(t *testing.T) {
+	defer func() { editor.cursor = vector{} }()
+
+	tests := []struct {
+		src, name string
+		at        string // the cursor goes at the start of this
+		want      int    // offset of the declaration, or -1 if none should be found
+	}{
+		{"package p; func f(a int) { b := a; _ = b }", "b", "_ = b", 27},
+		{"package p; func f(a int) { b := a; _ = b }", "a", "a; _", 18},
+		{"package p; func f(a int) { _ = a }; func g() { _ = a }", "a", "a }", -1},
+		{"package p; func f() { for i := range x { _ = i }; _ = i }", "i", "i }; _", 26},
+		{"package p; func f() { for i := range x { _ = i }; _ = i }", "i", "i }", -1},
+		{"func f(a int) { _ = a }", "a", "a }", -1},
+		{"", "a", "", -1},
+	}
+	for _, test := range tests {
+		fset := token.NewFileSet()
+		f, _ := parser.ParseFile(fset, "p.go", test.src, parser.SkipObjectResolution)
+		editor.cursor = vector{uint(strings.LastIndex(test.src, test.at)), 0}
+		loc, ok := local_decl(fset, f, test.name)
+		switch {
+		case test.want < 0 && ok:
+			t.Errorf("local_decl(%q) in %q found %v, want nothing", test.name, test.src, loc)
+		case test.want >= 0 && (!ok || loc.x != uint(test.want)):
+			t.Errorf("local_decl(%q) in %q = %v, %v, want column %d", test.name, test.src, loc, ok, test.want)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// An error from a build, and where it is
+type quickfix_entry struct {
+	file_loc
+	msg string
+}
+
+// the errors from the last build, and which one was last gone to
+var quickfix []quickfix_entry
+var quickfix_at = -1
+
+// what the make command runs when it isn't given a command of its own
+var build_command = "go build ./..."
+var building bool
+
+// file:line: message or file:line:col: message, as most compilers and linters write
+var error_line = regexp.MustCompile(`^(\S[^:]*):(\d+):(?:(\d+):)?\s*(.*)$`)
+
+// Pick out the errors from a build's output, skipping anything else it said
+func parse_errors(output string) []quickfix_entry {
+	var found []quickfix_entry
+	for _, line := range strings.Split(output, "\n") {
+		parts := error_line.FindStringSubmatch(strings.TrimRight(line, "\r"))
+		if parts == nil {
+			continue
+		}
+		y, _ := strconv.Atoi(parts[2])
+		x, _ := strconv.Atoi(parts[3])
+		// positions in messages count from 1
+		if y > 0 {
+			y--
+		}
+		if x > 0 {
+			x--
+		}
+		found = append(found, quickfix_entry{file_loc{parts[1], uint(y), uint(x)}, parts[4]})
+	}
+	return found
+}
+
+// Run a build command in the background and collect the errors it reports
+func make_project(cmd string) {
+	if building {
+		set_message("A build is already running")
+		return
+	}
+	building = true
+	set_message("Running %s...", cmd)
+
+	go func() {
+		out, err := exec.Command("sh", "-c", cmd).CombinedOutput()
+		post_event(func() {
+			building = false
+			quickfix = parse_errors(string(out))
+			quickfix_at = -1
+			// refresh the list if it's open, without pulling the user over to it
+			if find_list("quickfix") >= 0 {
+				current := editor.current
+				show_quickfix()
+				switch_buffer(current)
+			}
+
+			switch {
+			case len(quickfix) > 0:
+				set_message("%s: %d errors, cnext goes to the first", cmd, len(quickfix))
+			case err != nil:
+				// nothing that looks like an error, so show the last thing it said
+				said := last_said(string(out))
+				if said == "" {
+					said = err.Error()
+				}
+				set_message("%s failed: %s", cmd, said)
+			default:
+				set_message("%s succeeded", cmd)
+			}
+		})
+	}()
+}
+
+// Open the file an error is in, at the place it is about
+func go_to_error(i int) {
+	if i < 0 || i >= len(quickfix) {
+		return
+	}
+	quickfix_at = i
+	entry := quickfix[i]
+	if jump_to(entry.file_loc) {
+		set_message("(%d/%d) %s", i+1, len(quickfix), entry.msg)
+	}
+}
+
+// Go forwards or backwards through the errors
+func next_error(step int) {
+	if len(quickfix) == 0 {
+		set_message("No errors")
+		return
+	}
+	i := quickfix_at + step
+	if quickfix_at < 0 && step < 0 {
+		i = len(quickfix) - 1
+	}
+	if i < 0 || i >= len(quickfix) {
+		set_message("No more errors")
+		return
+	}
+	go_to_error(i)
+}
+
+// List the errors in a buffer, enter goes to the one under the cursor
+func show_quickfix() {
+	var lines []string
+	for _, entry := range quickfix {
+		lines = append(lines, fmt.Sprintf("%s:%d:%d: %s", entry.file, entry.y+1, entry.x+1, entry.msg))
+	}
+	show_list("quickfix", "[quickfix]", lines, func(row uint) {
+		go_to_error(int(row))
+	})
+}
+
+// Count of errors for the status bar, empty if there are none
+func quickfix_status() string {
+	switch {
+	case building:
+		return "[building] "
+	case len(quickfix) == 0:
+		return ""
+	case quickfix_at < 0:
+		return fmt.Sprintf("[%d errors] ", len(quickfix))
+	}
+	return fmt.Sprintf("[error %d/%d] ", quickfix_at+1, len(quickfix))
+}
+
+func cmd_make(args []string) {
+	cmd := build_command
+	if len(args) > 0 {
+		cmd = args[0]
+	}
+	make_project(cmd)
+}
+
+func cmd_build_command(args []string) {
+	if len(args) == 0 {
+		set_message("Build command is %s", build_command)
+		return
+	}
+	build_command = args[0]
+	set_message("Build command set to %s", build_command)
+}
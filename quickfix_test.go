@@ -0,0 +1,27 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseErrors(t *testing.T) {
+	output := "# editor\r\n" +
+		"./editor.go:12:5: undefined: foo\r\n" +
+		"lsp/client.go:40: missing return\n" +
+		"main.py:0:0: line zero\n" +
+		"FAIL\n" +
+		"\tat somewhere:not a number\n" +
+		": no file\n"
+	want := []quickfix_entry{
+		{file_loc{"./editor.go", 11, 4}, "undefined: foo"},
+		{file_loc{"lsp/client.go", 39, 0}, "missing return"},
+		{file_loc{"main.py", 0, 0}, "line zero"},
+	}
+	if got := parse_errors(output); !reflect.DeepEqual(got, want) {
+		t.Errorf("parse_errors() = %v, want %v", got, want)
+	}
+	if got := parse_errors("ok  \teditor\t0.01s\n"); got != nil {
+		t.Errorf("parse_errors() of a clean build = %v, want nothing", got)
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// A place in an expanded snippet for the cursor to go, and the length
+// of the placeholder text there to be typed over
+type snippet_stop struct {
+	num    int
+	y      uint
+	x      uint
+	length uint
+}
+
+// The snippet whose stops tab is moving through
+type active_snippet struct {
+	stops []snippet_stop
+	at    int
+	// the size of things on arriving at the current stop, so later stops
+	// can be moved by however much was typed there
+	rows        uint
+	line_len    uint
+	placeholder bool
+}
+
+var snippet *active_snippet
+
+// Lay a snippet body out at the cursor's indentation, turning the tabs that
+// indent it into whatever the buffer indents with
+func indent_snippet(body string, indent []byte) string {
+	lines := strings.Split(body, "\n")
+	for i, line := range lines {
+		tabs := len(line) - len(strings.TrimLeft(line, "\t"))
+		level := make_indent(uint(tabs)*editor.tab_width, editor.expand_tab)
+		lines[i] = string(level) + line[tabs:]
+		if i > 0 && line != "" {
+			lines[i] = string(indent) + lines[i]
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Take the stops out of a snippet, leaving their placeholders in the text
+// $n and ${n} are stops, ${n:text} is a stop with a placeholder, \$ is a dollar
+// Stops are positioned from the start of the text and sorted in the order
+// they are visited, with $0, or the end if there isn't one, last
+func parse_snippet(body string) ([]byte, []snippet_stop) {
+	var text []byte
+	var stops []snippet_stop
+	var y, x uint
+	has_end := false
+
+	// read a stop number starting at i, returning it and where it ends
+	number := func(i int) (int, int) {
+		n, start := 0, i
+		for i < len(body) && body[i] >= '0' && body[i] <= '9' {
+			n = n*10 + int(body[i]-'0')
+			i++
+		}
+		if i == start {
+			return -1, start
+		}
+		return n, i
+	}
+
+	for i := 0; i < len(body); i++ {
+		char := body[i]
+		switch {
+		case char == '\\' && i+1 < len(body) && strings.IndexByte("$\\}", body[i+1]) >= 0:
+			i++
+			text = append(text, body[i])
+			x++
+			continue
+		case char == '$' && i+1 < len(body) && body[i+1] == '{':
+			n, end := number(i + 2)
+			if n >= 0 && end < len(body) && (body[end] == '}' || body[end] == ':') {
+				placeholder := ""
+				if body[end] == ':' {
+					close := strings.IndexByte(body[end:], '}')
+					if close < 0 {
+						break
+					}
+					placeholder = body[end+1 : end+close]
+					end += close
+				}
+				stops = append(stops, snippet_stop{n, y, x, uint(len(placeholder))})
+				has_end = has_end || n == 0
+				text = append(text, placeholder...)
+				x += uint(len(placeholder))
+				i = end
+				continue
+			}
+		case char == '$':
+			if n, end := number(i + 1); n >= 0 {
+				stops = append(stops, snippet_stop{n, y, x, 0})
+				has_end = has_end || n == 0
+				i = end - 1
+				continue
+			}
+		}
+		text = append(text, char)
+		x++
+		if char == '\n' {
+			y++
+			x = 0
+		}
+	}
+	if !has_end {
+		stops = append(stops, snippet_stop{0, y, x, 0})
+	}
+
+	sort.SliceStable(stops, func(a, b int) bool {
+		if (stops[a].num == 0) != (stops[b].num == 0) {
+			return stops[b].num == 0
+		}
+		return stops[a].num < stops[b].num
+	})
+	return text, stops
+}
+
+// Expand the snippet whose trigger word is just before the cursor
+// Returns false if there isn't one
+func expand_snippet() bool {
+	if editor.language.Snippets == nil || editor.cursor.y >= editor.used_rows {
+		return false
+	}
+	line := &editor.lines[editor.cursor.y]
+	start := editor.cursor.x
+	for start > 0 && char_class(line.text[start-1]) == 2 {
+		start--
+	}
+	// the trigger has to be a whole word
+	if start == editor.cursor.x || editor.cursor.x < line.len && char_class(line.text[editor.cursor.x]) == 2 {
+		return false
+	}
+	body, ok := editor.language.Snippets[string(line.text[start:editor.cursor.x])]
+	if !ok {
+		return false
+	}
+
+	indent := append([]byte{}, leading_whitespace(line.text)...)
+	text, stops := parse_snippet(indent_snippet(body, indent))
+	y := editor.cursor.y
+	begin_edit(EDIT_OTHER)
+	replace_between(vector{start, y}, editor.cursor, text)
+	end_edit()
+
+	for i := range stops {
+		if stops[i].y == 0 {
+			stops[i].x += start
+		}
+		stops[i].y += y
+	}
+	snippet = &active_snippet{stops: stops, at: -1}
+	next_snippet_stop()
+	return true
+}
+
+// Move to the next stop of the snippet being filled in
+// Returns false if there isn't a snippet, or the cursor has left it
+func next_snippet_stop() bool {
+	s := snippet
+	if s == nil {
+		return false
+	}
+	if s.at == len(s.stops)-1 {
+		snippet = nil
+		return false
+	}
+	if s.at >= 0 {
+		current := s.stops[s.at]
+		grown := int(editor.used_rows) - int(s.rows)
+		// anywhere else and tab goes back to meaning tab
+		if int(editor.cursor.y) < int(current.y) || int(editor.cursor.y) > int(current.y)+grown {
+			snippet = nil
+			return false
+		}
+		widened := int(editor.lines[current.y].len) - int(s.line_len)
+		for i := s.at + 1; i < len(s.stops); i++ {
+			stop := &s.stops[i]
+			if stop.y == current.y && grown == 0 && stop.x >= current.x {
+				stop.x = uint(int(stop.x) + widened)
+			} else if stop.y > current.y {
+				stop.y = uint(int(stop.y) + grown)
+			}
+		}
+	}
+
+	s.at++
+	stop := s.stops[s.at]
+	set_cursor(stop.y, stop.x)
+	s.rows = editor.used_rows
+	s.line_len = editor.lines[stop.y].len
+	s.placeholder = stop.length > 0
+	if s.placeholder {
+		// shown selected, and typing replaces it
+		editor.anchor = vector{stop.x + stop.length, stop.y}
+		editor.selecting = true
+	}
+	// done once the cursor is at the end, unless there's still something to type over
+	if s.at == len(s.stops)-1 && !s.placeholder {
+		snippet = nil
+	}
+	return true
+}
+
+// Typing at a stop with a placeholder replaces the placeholder
+// Returns true if the key was used up deleting it
+func replace_placeholder(c uint) bool {
+	s := snippet
+	if s == nil || !s.placeholder {
+		return false
+	}
+	s.placeholder = false
+	stop := s.stops[s.at]
+	if !editor.selecting || editor.cursor != (vector{stop.x, stop.y}) {
+		return false
+	}
+	if c != KEY_BACKSPACE && c != KEY_DEL && (c < 0x20 || c > 0xFF) {
+		return false
+	}
+	deleting := c == KEY_BACKSPACE || c == KEY_DEL
+	// a character typed over the placeholder goes in the same undo step as deleting it
+	if deleting {
+		begin_edit(EDIT_DELETE)
+	} else {
+		begin_edit(EDIT_TYPE)
+	}
+	delete_between(stop.x, stop.x+stop.length)
+	editor.selecting = false
+	return deleting
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSnippet(t *testing.T) {
+	tests := []struct {
+		body  string
+		text  string
+		stops []snippet_stop
+	}{
+		{"plain", "plain", []snippet_stop{{0, 0, 5, 0}}},
+		{"if $1 {\n\t$0\n}", "if  {\n\t\n}", []snippet_stop{{1, 0, 3, 0}, {0, 1, 1, 0}}},
+		{"for ${1:i} := 0; $1 < ${2:n}; $1++ {\n\t$0\n}", "for i := 0;  < n; ++ {\n\t\n}",
+			[]snippet_stop{{1, 0, 4, 1}, {1, 0, 12, 0}, {1, 0, 18, 0}, {2, 0, 15, 1}, {0, 1, 1, 0}}},
+		{"${2:b} ${1:a}", "b a", []snippet_stop{{1, 0, 2, 1}, {2, 0, 0, 1}, {0, 0, 3, 0}}},
+		{"${0:end} $1", "end ", []snippet_stop{{1, 0, 4, 0}, {0, 0, 0, 3}}},
+		{`cost \$5 \\ \}`, `cost $5 \ }`, []snippet_stop{{0, 0, 11, 0}}},
+		{"$ ${x} ${1:open", "$ ${x} ${1:open", []snippet_stop{{0, 0, 15, 0}}},
+		{"$12$3", "", []snippet_stop{{3, 0, 0, 0}, {12, 0, 0, 0}, {0, 0, 0, 0}}},
+	}
+	for _, test := range tests {
+		text, stops := parse_snippet(test.body)
+		if string(text) != test.text || !reflect.DeepEqual(stops, test.stops) {
+			t.Errorf("parse_snippet(%q) = %q, %v, want %q, %v", test.body, text, stops, test.text, test.stops)
+		}
+	}
+}
+
+// Typing over a placeholder is undone in one go
+func TestReplacePlaceholderUndo(t *testing.T) {
+	saved := editor.buffer_t
+	defer func() {
+		editor.buffer_t = saved
+		snippet = nil
+	}()
+	editor.buffer_t = empty_buffer()
+	add_line(0, []byte("for i := 0"))
+	snippet = &active_snippet{stops: []snippet_stop{{1, 0, 4, 1}, {0, 0, 10, 0}}, placeholder: true}
+	editor.cursor = vector{4, 0}
+	editor.anchor = vector{5, 0}
+	editor.selecting = true
+
+	if replace_placeholder('j') {
+		t.Fatal("a typed character was used up deleting the placeholder")
+	}
+	begin_edit(EDIT_TYPE)
+	type_char('j')
+	if got := string(editor.lines[0].text); got != "for j := 0" {
+		t.Fatalf("typing over the placeholder gave %q", got)
+	}
+	undo()
+	if got := string(editor.lines[0].text); got != "for i := 0" || len(editor.undo) != 0 {
+		t.Errorf("undo gave %q with %d steps left, want the placeholder back in one step", got, len(editor.undo))
+	}
+}
@@ -3,13 +3,39 @@ package syntax
 import "path/filepath"
 
 type Syntax struct {
-	Is_highlighted  bool
-	In_line_comment []byte
-	// start_block_comment []byte
-	// end_block_comment   []byte
-	Keywords []string
+	Is_highlighted      bool
+	In_line_comment     []byte
+	Start_block_comment []byte
+	End_block_comment   []byte
+	Keywords            []string
+	// characters that separate words, nil uses the editor's defaults
+	Delimiters []byte
+
+	// indentation defaults for the language
+	Tab_width  uint
+	Expand_tab bool
+
+	// a line ending in one of these indents the next line
+	Indent_after []string
+	// typing one of these at the start of a line dedents it
+	Dedent_on []string
+
+	// shell command that formats code from stdin to stdout before saving,
+	// or GO_FORMAT for the standard library's go/format
+	// Only Go has one to start with, others are set with the formatter command
+	Formatter string
+
+	// command that runs a language server over stdio, and the
+	// name the protocol knows the language by
+	Language_server []string
+	Language_id     string
+
+	// snippet bodies by the word that expands them
+	Snippets map[string]string
 }
 
+const GO_FORMAT = "go/format"
+
 var syntax Syntax
 
 const (
@@ -22,23 +48,39 @@ const (
 func Setup_syntax(file_name string) Syntax {
 	ext := filepath.Ext(file_name)
 
-	syntax.Is_highlighted = true
+	// start from nothing so no setting carries over from the last file
+	syntax = Syntax{Is_highlighted: true, Tab_width: 4}
 
 	switch ext {
 	case py:
 		syntax.In_line_comment = []byte("#")
+		syntax.Expand_tab = true
+		syntax.Indent_after = []string{":"}
+		syntax.Delimiters = []byte(",.()+-/*=~%<>[]{}:;!&|^@'\" \t\n\r")
+		syntax.Dedent_on = []string{"else:", "elif ", "except:", "except ", "finally:"}
+		syntax.Language_server = []string{"pyright-langserver", "--stdio"}
+		syntax.Language_id = "python"
 		syntax.Keywords = []string{"False|", "None|", "True|", "and|", "as", "assert", "break", "class|",
 			"continue", "def|", "del", "elif", "else", "except", "finally", "for", "from", "global|",
 			"if", "import", "in|", "is|", "lambda|", "nonlocal|", "not|", "or|", "pass", "raise", "return",
 			"try", "while", "with", "yield"}
 	case c:
 		syntax.In_line_comment = []byte("//")
+		syntax.Start_block_comment = []byte("/*")
+		syntax.End_block_comment = []byte("*/")
 		syntax.Keywords = []string{"switch", "if", "while", "for", "break", "continue", "return", "else",
 			"struct", "union", "typedef", "static", "enum", "class", "case",
 			"int|", "long|", "double|", "float|", "char|", "unsigned|", "signed|",
 			"void|"}
+		syntax.Indent_after = []string{"{", "(", "["}
+		syntax.Delimiters = []byte(",.()+-/*=~%<>[]{}:;!&|^?'\" \t\n\r")
+		syntax.Dedent_on = []string{"}", ")", "]"}
+		syntax.Language_server = []string{"clangd"}
+		syntax.Language_id = "c"
 	case golang:
 		syntax.In_line_comment = []byte("//")
+		syntax.Start_block_comment = []byte("/*")
+		syntax.End_block_comment = []byte("*/")
 		syntax.Keywords = []string{"break", "case", "chan", "const",
 			"continue", "default", "defer", "else", "fallthrough", "for", "func",
 			"go", "goto", "if", "import", "interface", "map", "package", "range",
@@ -46,9 +88,16 @@ func Setup_syntax(file_name string) Syntax {
 			"bool|", "string|", "int|", "int8|", "int16|", "int32|", "int64|",
 			"uint|", "uint8|", "uint16|", "uint32|", "uint64|", "byte|", "rune|",
 			"float32|", "float64|", "complex64|", "complex128|", "uintptr|"}
+		syntax.Indent_after = []string{"{", "(", "["}
+		syntax.Delimiters = []byte(",.()+-/*=~%<>[]{}:;!&|^?'\" \t\n\r")
+		syntax.Dedent_on = []string{"}", ")", "]"}
+		syntax.Formatter = GO_FORMAT
+		syntax.Language_server = []string{"gopls"}
+		syntax.Language_id = "go"
 	default:
 		syntax.Is_highlighted = false
 	}
+	syntax.Snippets = load_snippets(syntax.Language_id)
 
 	return syntax
 }
@@ -0,0 +1,59 @@
+package syntax
+
+import (
+	"embed"
+	"strings"
+)
+
+// each language's snippets are in snippets/<language id>.snippets
+//
+//go:embed snippets
+var snippet_files embed.FS
+
+// snippets already read, by language id
+var snippets = map[string]map[string]string{}
+
+// Read the snippets for a language, mapping each trigger word to its body
+// A snippet starts with a line "snippet <trigger>" and its body is the lines
+// after it indented by a tab, which is taken off
+func load_snippets(language_id string) map[string]string {
+	if found, ok := snippets[language_id]; ok {
+		return found
+	}
+	found := map[string]string{}
+	snippets[language_id] = found
+
+	data, err := snippet_files.ReadFile("snippets/" + language_id + ".snippets")
+	if err != nil {
+		return found
+	}
+	trigger := ""
+	var body []string
+	end := func() {
+		// blank lines between snippets aren't part of them
+		for len(body) > 0 && body[len(body)-1] == "" {
+			body = body[:len(body)-1]
+		}
+		if trigger != "" {
+			found[trigger] = strings.Join(body, "\n")
+		}
+		trigger, body = "", nil
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, "\r")
+		switch {
+		case strings.HasPrefix(line, "snippet "):
+			end()
+			trigger = strings.TrimSpace(line[len("snippet "):])
+		case strings.HasPrefix(line, "\t") && trigger != "":
+			body = append(body, line[1:])
+		case line == "" && trigger != "":
+			body = append(body, "")
+		case strings.HasPrefix(line, "#") || line == "":
+		default:
+			end()
+		}
+	}
+	end()
+	return found
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// An entry from a ctags file
+type tag_entry struct {
+	name    string
+	file    string // relative to the directory the tags file is in
+	address string // a line number or a /pattern/ to search for
+	kind    string
+}
+
+// the tags from the last tags file read, kept until the file changes
+var tags_path string
+var tags_time time.Time
+var tags map[string][]tag_entry
+
+// Look for a tags file in the current directory and the ones above it
+func find_tags_file() string {
+	dir, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+	for {
+		path := filepath.Join(dir, "tags")
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// Split a line of a tags file into its entry
+// Lines look like name<tab>file<tab>address;"<tab>kind<tab>more fields
+func parse_tag_line(line string) (tag_entry, bool) {
+	if strings.HasPrefix(line, "!_TAG_") {
+		return tag_entry{}, false
+	}
+	parts := strings.SplitN(line, "\t", 3)
+	if len(parts) < 3 {
+		return tag_entry{}, false
+	}
+	entry := tag_entry{name: parts[0], file: parts[1]}
+
+	address := parts[2]
+	fields := ""
+	if i := strings.Index(address, ";\"\t"); i >= 0 {
+		address, fields = address[:i], address[i+3:]
+	} else {
+		address = strings.TrimSuffix(address, ";\"")
+	}
+	entry.address = address
+	for _, field := range strings.Split(fields, "\t") {
+		// the kind is either on its own or given as kind:name
+		if len(field) == 1 {
+			entry.kind = field
+		} else if strings.HasPrefix(field, "kind:") {
+			entry.kind = field[5:]
+		}
+	}
+	return entry, true
+}
+
+// Read the project's tags file, unless it's the one already read and unchanged
+func load_tags() error {
+	path := find_tags_file()
+	if path == "" {
+		return fmt.Errorf("no tags file found")
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if path == tags_path && info.ModTime().Equal(tags_time) {
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	found := map[string][]tag_entry{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		if entry, ok := parse_tag_line(scanner.Text()); ok {
+			found[entry.name] = append(found[entry.name], entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	tags, tags_path, tags_time = found, path, info.ModTime()
+	return nil
+}
+
+// Turn a ctags search pattern back into the line it was made from
+// Patterns are /^line$/ with / and \ escaped, or ?^line$? for backwards searches
+func unescape_tag_pattern(pattern string) (string, bool, bool) {
+	pattern = pattern[1 : len(pattern)-1]
+	from_start := strings.HasPrefix(pattern, "^")
+	pattern = strings.TrimPrefix(pattern, "^")
+	to_end := strings.HasSuffix(pattern, "$") && !strings.HasSuffix(pattern, "\\$")
+	if to_end {
+		pattern = pattern[:len(pattern)-1]
+	}
+
+	var text []byte
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] == '\\' && i+1 < len(pattern) {
+			i++
+		}
+		text = append(text, pattern[i])
+	}
+	return string(text), from_start, to_end
+}
+
+// Find the line a tag's address points at in the current buffer
+func tag_line(address string) (uint, bool) {
+	if n, err := strconv.Atoi(address); err == nil && n > 0 {
+		return uint(n - 1), true
+	}
+	if len(address) < 2 || address[0] != '/' && address[0] != '?' {
+		return 0, false
+	}
+	text, from_start, to_end := unescape_tag_pattern(address)
+	for y := uint(0); y < editor.used_rows; y++ {
+		line := string(editor.lines[y].text)
+		switch {
+		case from_start && to_end && line == text,
+			from_start && !to_end && strings.HasPrefix(line, text),
+			!from_start && to_end && strings.HasSuffix(line, text),
+			!from_start && !to_end && strings.Contains(line, text):
+			return y, true
+		}
+	}
+	return 0, false
+}
+
+// Open the file a tag is in and put the cursor on its name
+func jump_to_tag(entry tag_entry) {
+	file := entry.file
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(filepath.Dir(tags_path), file)
+		if rel, err := filepath.Rel(".", file); err == nil {
+			file = rel
+		}
+	}
+	if !open_jump(file) {
+		return
+	}
+	y, ok := tag_line(entry.address)
+	if !ok {
+		set_message("Couldn't find %s in %s, the tags file may be out of date", entry.name, file)
+		return
+	}
+	x := 0
+	if y < editor.used_rows {
+		x = strings.Index(string(editor.lines[y].text), entry.name)
+		if x < 0 {
+			x = 0
+		}
+	}
+	set_cursor(y, uint(x))
+}
+
+// Jump to where a name is defined according to the tags file,
+// asking which if it's defined in more than one place
+func jump_to_tag_name(name string) {
+	if err := load_tags(); err != nil {
+		set_message("Couldn't read tags: %s", err)
+		return
+	}
+	found := tags[name]
+	i := 0
+	switch {
+	case len(found) == 0:
+		set_message("No tag for %s", name)
+		return
+	case len(found) > 1:
+		items := make([]string, len(found))
+		for j, entry := range found {
+			items[j] = fmt.Sprintf("%s %s  %s", entry.kind, entry.name, entry.file)
+		}
+		if i = pick(fmt.Sprintf("%d tags for %s: %%s", len(found), name), items); i < 0 {
+			return
+		}
+	}
+	jump_to_tag(found[i])
+}
+
+// Names in the tags file that start with the given text
+func complete_tag(prefix string) []string {
+	if load_tags() != nil {
+		return nil
+	}
+	var names []string
+	for name := range tags {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func cmd_tag(args []string) {
+	name := cursor_word()
+	if len(args) > 0 {
+		name = args[0]
+	}
+	if name == "" {
+		set_message("Usage: tag name")
+		return
+	}
+	jump_to_tag_name(name)
+}
@@ -0,0 +1,45 @@
+package main
+
+import "testing"
+
+func TestParseTagLine(t *testing.T) {
+	tests := []struct {
+		line string
+		want tag_entry
+		ok   bool
+	}{
+		{"!_TAG_FILE_FORMAT\t2\t/extended format/", tag_entry{}, false},
+		{"main\tmain.go", tag_entry{}, false},
+		{"main\tmain.go\t12", tag_entry{"main", "main.go", "12", ""}, true},
+		{"main\tmain.go\t/^func main() {$/;\"\tf", tag_entry{"main", "main.go", "/^func main() {$/", "f"}, true},
+		{"buf\teditor.go\t/^type buf struct {$/;\"\tkind:type\tline:40", tag_entry{"buf", "editor.go", "/^type buf struct {$/", "type"}, true},
+		{"x\tsub/x.c\t?^int x;$?;\"", tag_entry{"x", "sub/x.c", "?^int x;$?", ""}, true},
+		{"tab\tt.c\t/^\tif (a\\/b)$/;\"\tv", tag_entry{"tab", "t.c", "/^\tif (a\\/b)$/", "v"}, true},
+	}
+	for _, test := range tests {
+		got, ok := parse_tag_line(test.line)
+		if ok != test.ok || got != test.want {
+			t.Errorf("parse_tag_line(%q) = %+v, %v, want %+v, %v", test.line, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestUnescapeTagPattern(t *testing.T) {
+	tests := []struct {
+		pattern, text      string
+		from_start, to_end bool
+	}{
+		{"/^func main() {$/", "func main() {", true, true},
+		{"/^type buf struct/", "type buf struct", true, false},
+		{"/a\\/b\\\\c/", "a/b\\c", false, false},
+		{"?^cost \\$5$?", "cost $5", true, true},
+		{"/ends with \\$/", "ends with $", false, false},
+	}
+	for _, test := range tests {
+		text, from_start, to_end := unescape_tag_pattern(test.pattern)
+		if text != test.text || from_start != test.from_start || to_end != test.to_end {
+			t.Errorf("unescape_tag_pattern(%q) = %q, %v, %v, want %q, %v, %v", test.pattern,
+				text, from_start, to_end, test.text, test.from_start, test.to_end)
+		}
+	}
+}
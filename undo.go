@@ -0,0 +1,84 @@
+package main
+
+// kinds of edits, used to group keystrokes into a single undo step
+const (
+	EDIT_NONE byte = iota
+	EDIT_TYPE
+	EDIT_DELETE
+	EDIT_OTHER
+)
+
+const UNDO_LIMIT = 200
+
+// A copy of the contents of the editor that can be restored
+type snapshot struct {
+	lines  [][]byte
+	cursor vector
+}
+
+func take_snapshot() snapshot {
+	var snap snapshot
+	snap.lines = make([][]byte, len(editor.lines))
+	for i, line := range editor.lines {
+		snap.lines[i] = append([]byte{}, line.text...)
+	}
+	snap.cursor = editor.cursor
+	return snap
+}
+
+func restore_snapshot(snap snapshot) {
+	editor.lines = make([]line_t, len(snap.lines))
+	for i, text := range snap.lines {
+		editor.lines[i].text = append([]byte{}, text...)
+		editor.lines[i].len = uint(len(text))
+		highlight_line(&editor.lines[i])
+	}
+	editor.used_rows = uint(len(editor.lines))
+	editor.cursor = snap.cursor
+	editor.selecting = false
+}
+
+// Record the state of the editor before an edit so that it can be undone
+// Consecutive typing or deleting on the same line is grouped into one step
+func begin_edit(kind byte) {
+	if kind != EDIT_OTHER && kind == editor.last_edit && editor.cursor.y == editor.last_edit_y {
+		return
+	}
+	editor.last_edit = kind
+	editor.last_edit_y = editor.cursor.y
+
+	editor.undo = append(editor.undo, take_snapshot())
+	if len(editor.undo) > UNDO_LIMIT {
+		editor.undo = editor.undo[1:]
+	}
+	editor.redo = nil
+}
+
+// Stop grouping edits, so the next one starts a new undo step
+func end_edit() {
+	editor.last_edit = EDIT_NONE
+}
+
+func undo() {
+	if len(editor.undo) == 0 {
+		set_message("Nothing to undo")
+		return
+	}
+	editor.redo = append(editor.redo, take_snapshot())
+	restore_snapshot(editor.undo[len(editor.undo)-1])
+	editor.undo = editor.undo[:len(editor.undo)-1]
+	end_edit()
+	modified()
+}
+
+func redo() {
+	if len(editor.redo) == 0 {
+		set_message("Nothing to redo")
+		return
+	}
+	editor.undo = append(editor.undo, take_snapshot())
+	restore_snapshot(editor.redo[len(editor.redo)-1])
+	editor.redo = editor.redo[:len(editor.redo)-1]
+	end_edit()
+	modified()
+}
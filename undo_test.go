@@ -0,0 +1,46 @@
+package main
+
+import "testing"
+
+// Run keys through the editor as if they had been typed
+func press(keys_pressed ...uint) {
+	for _, key := range keys_pressed {
+		go func(key uint) { keys <- key }(key)
+		handle_key_event()
+	}
+}
+
+func TestUndoGrouping(t *testing.T) {
+	saved := editor.buffer_t
+	defer func() { editor.buffer_t = saved }()
+
+	tests := []struct {
+		name  string
+		keys  []uint
+		after string
+	}{
+		{"typing", []uint{'a', 'b', 'c'}, "abchello world"},
+		{"backspace", []uint{KEY_END, KEY_BACKSPACE, KEY_BACKSPACE, KEY_BACKSPACE}, "hello wo"},
+		{"delete", []uint{KEY_DEL, KEY_DEL, KEY_DEL}, "lo world"},
+	}
+	for _, test := range tests {
+		set_buffer_text("hello world")
+		editor.cursor = vector{0, 0}
+		press(test.keys...)
+		if got := buffer_lines(); got != test.after {
+			t.Errorf("%s gave %q, want %q", test.name, got, test.after)
+		}
+		undo()
+		if got := buffer_lines(); got != "hello world" || len(editor.undo) != 0 {
+			t.Errorf("one undo after %s gave %q with %d steps left, want it all undone", test.name, got, len(editor.undo))
+		}
+	}
+
+	// moving between edits starts a new step
+	set_buffer_text("hello world")
+	editor.cursor = vector{0, 0}
+	press(KEY_DEL, KEY_RIGHT, KEY_DEL)
+	if len(editor.undo) != 2 {
+		t.Errorf("deletes either side of a move took %d undo steps, want 2", len(editor.undo))
+	}
+}